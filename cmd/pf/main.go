@@ -115,6 +115,7 @@ type buildpackTOML struct {
 	API       string           `toml:"api"`
 	Buildpack buildpackInfo    `toml:"buildpack"`
 	Stacks    []packfile.Stack `toml:"stacks"`
+	Metadata  *buildpackMeta   `toml:"metadata,omitempty"`
 }
 
 type buildpackInfo struct {
@@ -123,6 +124,10 @@ type buildpackInfo struct {
 	Name    string `toml:"name"`
 }
 
+type buildpackMeta struct {
+	Vars []packfile.Var `toml:"vars"`
+}
+
 var packfileBuildpack = buildpackTOML{
 	API: "0.2",
 	Buildpack: buildpackInfo{
@@ -150,6 +155,9 @@ func getBuildpackTOML(pf *packfile.Packfile) buildpackTOML {
 		Version: pf.Config.Version,
 		Name:    pf.Config.Name,
 	}
+	if len(pf.Config.Vars) != 0 {
+		out.Metadata = &buildpackMeta{Vars: pf.Config.Vars}
+	}
 	return out
 }
 
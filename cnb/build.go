@@ -73,34 +73,67 @@ func Build(pf *packfile.Packfile, ctxDir, layersDir, platformDir, planPath strin
 	if _, err := toml.DecodeFile(planPath, &plan); err != nil {
 		return err
 	}
+	vars, varEnv, err := resolveVars(pf.Config.Vars, platformDir)
+	if err != nil {
+		return err
+	}
+	cond := newConditions(platformDir, appDir)
 	lock := sync.NewLock()
 	var linkLayers []link.Layer
 	layerNames := map[string]struct{}{}
 	for i := range pf.Caches {
 		cache := &pf.Caches[i]
+		if ok, err := cond.match(cache.When, nil); err != nil {
+			return xerrors.Errorf("invalid condition for cache '%s': %w", cache.Name, err)
+		} else if !ok {
+			continue
+		}
 		layerNames[cache.Name] = struct{}{}
+		mdDir, err := ioutil.TempDir("", "packfile.md."+cache.Name)
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(mdDir)
 		cacheLayer := &layers.Cache{
 			Streamer: sync.NewStreamer(),
 			Share: link.Share{
 				LayerDir: filepath.Join(layersDir, pf.Caches[i].Name),
+				Metadata: metadata.NewFS(mdDir),
 			},
-			Kernel: sync.NewKernel(cache.Name, lock, false),
-			Cache:  cache,
-			AppDir: appDir,
+			Kernel:      sync.NewKernel(cache.Name, lock, false),
+			Cache:       cache,
+			AppDir:      appDir,
+			PlatformDir: platformDir,
+			VarEnv:      varEnv,
 		}
 		if setup := cache.Setup; setup != nil {
 			if setup.Runner != nil {
+				cacheLayer.Metadata = metadata.NewMemory()
 				cacheLayer.SetupRunner = setup.Runner
 			} else {
 				cacheLayer.SetupRunner = &exec.Exec{
-					Exec:   shellOverride(setup.Exec, shell),
-					Name:   cache.Name,
-					CtxDir: ctxDir,
+					Exec:         setup.Exec,
+					Name:         cache.Name,
+					CtxDir:       ctxDir,
+					DefaultShell: shell,
+				}
+			}
+		}
+		if prune := cache.Prune; prune != nil {
+			if prune.Runner != nil {
+				cacheLayer.PruneRunner = prune.Runner
+			} else {
+				cacheLayer.PruneRunner = &exec.Exec{
+					Exec:         prune.Exec,
+					Name:         cache.Name,
+					CtxDir:       ctxDir,
+					DefaultShell: shell,
 				}
 			}
 		}
 		linkLayers = append(linkLayers, cacheLayer)
 	}
+	restoring := map[string]bool{}
 	for i := range pf.Layers {
 		layer := &pf.Layers[i]
 		if layer.Provide != nil && layer.Build != nil {
@@ -109,12 +142,22 @@ func Build(pf *packfile.Packfile, ctxDir, layersDir, platformDir, planPath strin
 		if layer.Build == nil && layer.Provide == nil {
 			continue
 		}
+		if ok, err := cond.match(layer.When, layerMetadata(layer)); err != nil {
+			return xerrors.Errorf("invalid condition for layer '%s': %w", layer.Name, err)
+		} else if !ok {
+			continue
+		}
 		layerNames[layer.Name] = struct{}{}
 		mdDir, err := ioutil.TempDir("", "packfile.md."+layer.Name)
 		if err != nil {
 			return err
 		}
 		defer os.RemoveAll(mdDir)
+		launchDir, err := ioutil.TempDir("", "packfile.launch."+layer.Name)
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(launchDir)
 		layerDir := filepath.Join(layersDir, layer.Name)
 		buildLayer := &layers.Build{
 			Streamer: sync.NewStreamer(),
@@ -125,17 +168,29 @@ func Build(pf *packfile.Packfile, ctxDir, layersDir, platformDir, planPath strin
 			Layer:       layer,
 			Requires:    plan.get(layer.Name),
 			AppDir:      appDir,
+			PlatformDir: platformDir,
+			Vars:        vars,
+			VarEnv:      varEnv,
 			BuildID:     store.Metadata.BuildID,
 			LastBuildID: lastBuildID,
 		}
+		if layer.Restore {
+			buildLayer.RestoreDir = filepath.Join(layersDir, restoreLayer, layer.Name)
+			if err := writeTOML(restoreTOML{Cache: true}, filepath.Join(layersDir, restoreLayer+".toml")); err != nil {
+				return err
+			}
+			layerNames[restoreLayer] = struct{}{}
+			restoring[layer.Name] = true
+		}
 		if test := layer.FindProvide().Test; test != nil {
 			if test.Runner != nil {
 				buildLayer.TestRunner = test.Runner
-			} else if test.Exec != (packfile.Exec{}) {
+			} else if hasExec(test.Exec) {
 				buildLayer.TestRunner = &exec.Exec{
-					Exec:   shellOverride(test.Exec, shell),
-					Name:   layer.Name,
-					CtxDir: ctxDir,
+					Exec:         test.Exec,
+					Name:         layer.Name,
+					CtxDir:       ctxDir,
+					DefaultShell: shell,
 				}
 			} else if len(test.Match) > 0 {
 				buildLayer.TestRunner = &matchTest{
@@ -147,18 +202,24 @@ func Build(pf *packfile.Packfile, ctxDir, layersDir, platformDir, planPath strin
 		if run := layer.FindProvide().Run; run != nil {
 			if run.Runner != nil {
 				buildLayer.Metadata = metadata.NewMemory()
+				buildLayer.Launch = metadata.NewMemory()
 				buildLayer.ProvideRunner = run.Runner
 			} else {
 				buildLayer.Metadata = metadata.NewFS(mdDir)
+				buildLayer.Launch = metadata.NewFS(launchDir)
 				buildLayer.ProvideRunner = &exec.Exec{
-					Exec:   shellOverride(run.Exec, shell),
-					Name:   layer.Name,
-					CtxDir: ctxDir,
+					Exec:         run.Exec,
+					Name:         layer.Name,
+					CtxDir:       ctxDir,
+					DefaultShell: shell,
 				}
 			}
 		}
 		linkLayers = append(linkLayers, buildLayer)
 	}
+	if err := pruneRestore(layersDir, restoring); err != nil {
+		return err
+	}
 	if err := eachDir(layersDir, func(name string) error {
 		if _, ok := layerNames[name]; !ok {
 			if err := os.RemoveAll(filepath.Join(layersDir, name)); err != nil {
@@ -183,9 +244,23 @@ func Build(pf *packfile.Packfile, ctxDir, layersDir, platformDir, planPath strin
 	for i := range linkLayers {
 		sync.WaitForNode(linkLayers[i])
 	}
+	for _, l := range linkLayers {
+		if c, ok := l.(*layers.Cache); ok && sync.NodeError(c) == nil {
+			if err := c.Save(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := verifyLayers(pf.Config.Verify, appDir, linkLayers); err != nil {
+		return err
+	}
+	launch, err := layers.NewLaunch(pf, linkLayers, appDir, platformDir, vars)
+	if err != nil {
+		return err
+	}
 	if err := writeTOML(launchTOML{
-		Processes: pf.Processes,
-		Slices:    pf.Slices,
+		Processes: launch.Processes,
+		Slices:    launch.Slices,
 	}, filepath.Join(layersDir, "launch.toml")); err != nil {
 		return err
 	}
@@ -199,9 +274,44 @@ func Build(pf *packfile.Packfile, ctxDir, layersDir, platformDir, planPath strin
 	return writeTOML(store, storePath)
 }
 
+// restoreLayer is a cache layer that holds copies of exported layers that are not stored
+const restoreLayer = "packfile-restore"
+
+type restoreTOML struct {
+	Cache bool `toml:"cache"`
+}
+
+// pruneRestore removes copies of layers that are not restored in this build, including layers that were removed or renamed
+func pruneRestore(layersDir string, keep map[string]bool) error {
+	restoreDir := filepath.Join(layersDir, restoreLayer)
+	if len(keep) == 0 {
+		if err := os.RemoveAll(restoreDir); err != nil {
+			return err
+		}
+		if err := os.Remove(restoreDir + ".toml"); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	names, err := ioutil.ReadDir(restoreDir)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	for _, fi := range names {
+		if !keep[fi.Name()] {
+			if err := os.RemoveAll(filepath.Join(restoreDir, fi.Name())); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
 func fullEnv(l *packfile.Layer) bool {
 	if p := l.FindProvide(); p.Test != nil {
 		return p.Test.FullEnv
 	}
 	return false
-}
\ No newline at end of file
+}
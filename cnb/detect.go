@@ -4,6 +4,8 @@ import (
 	"io/ioutil"
 	"os"
 
+	"golang.org/x/xerrors"
+
 	"github.com/sclevine/packfile"
 	"github.com/sclevine/packfile/exec"
 	"github.com/sclevine/packfile/layers"
@@ -19,6 +21,7 @@ type planProvide struct {
 type planSections struct {
 	Requires []link.Require `toml:"requires"`
 	Provides []planProvide  `toml:"provides"`
+	Or       []planSections `toml:"or,omitempty"`
 }
 
 func Detect(pf *packfile.Packfile, ctxDir, platformDir, planPath string) error {
@@ -30,13 +33,31 @@ func Detect(pf *packfile.Packfile, ctxDir, platformDir, planPath string) error {
 	if s := pf.Config.Shell; s != "" {
 		shell = s
 	}
+	vars, varEnv, err := resolveVars(pf.Config.Vars, platformDir)
+	if err != nil {
+		return err
+	}
+	cond := newConditions(platformDir, appDir)
 	lock := sync.NewLock()
 	var provides []planProvide
+	external := map[string][]link.Require{}
 	var linkLayers []link.Layer
 	for i := range pf.Layers {
 		layer := &pf.Layers[i]
+		if ok, err := cond.match(layer.When, layerMetadata(layer)); err != nil {
+			return xerrors.Errorf("invalid condition for layer '%s': %w", layer.Name, err)
+		} else if !ok {
+			continue
+		}
 		if layer.Provide != nil || layer.Build != nil {
 			provides = append(provides, planProvide{Name: layer.Name})
+			for _, req := range layer.FindProvide().Requires {
+				external[layer.Name] = append(external[layer.Name], link.Require{
+					Name:     req.Name,
+					Version:  req.Version,
+					Metadata: req.Metadata,
+				})
+			}
 		}
 		if layer.Require == nil && layer.Build == nil {
 			continue
@@ -47,21 +68,32 @@ func Detect(pf *packfile.Packfile, ctxDir, platformDir, planPath string) error {
 		}
 		defer os.RemoveAll(mdDir)
 		detectLayer := &layers.Detect{
-			Streamer: sync.NewStreamer(),
-			Kernel:   sync.NewKernel(layer.Name, lock, false),
-			Layer:    layer,
-			AppDir:   appDir,
+			Streamer:    sync.NewStreamer(),
+			Kernel:      sync.NewKernel(layer.Name, lock, false),
+			Layer:       layer,
+			AppDir:      appDir,
+			PlatformDir: platformDir,
+			Vars:        vars,
+			VarEnv:      varEnv,
 		}
 		if require := layer.Require; require != nil {
 			if require.Runner != nil {
 				detectLayer.Metadata = metadata.NewMemory()
+				detectLayer.Launch = metadata.NewMemory()
 				detectLayer.RequireRunner = require.Runner
 			} else {
+				launchDir, err := ioutil.TempDir("", "packfile.launch."+layer.Name)
+				if err != nil {
+					return err
+				}
+				defer os.RemoveAll(launchDir)
 				detectLayer.Metadata = metadata.NewFS(mdDir)
+				detectLayer.Launch = metadata.NewFS(launchDir)
 				detectLayer.RequireRunner = &exec.Exec{
-					Exec:   shellOverride(require.Exec, shell),
-					Name:   layer.Name,
-					CtxDir: ctxDir,
+					Exec:         require.Exec,
+					Name:         layer.Name,
+					CtxDir:       ctxDir,
+					DefaultShell: shell,
 				}
 			}
 		} else {
@@ -87,5 +119,48 @@ func Detect(pf *packfile.Packfile, ctxDir, platformDir, planPath string) error {
 	if err != nil {
 		return err
 	}
-	return writeTOML(planSections{requires, provides}, planPath)
+	if err := layers.AddRequireLaunch(requires, linkLayers); err != nil {
+		return err
+	}
+	// external requires are dropped for layers that fail to require themselves
+	for _, l := range linkLayers {
+		if exec.IsFail(sync.NodeError(l)) {
+			delete(external, l.Info().Name)
+		}
+	}
+	plan := planGroup(pf.Plan, requires, provides, external)
+	for _, alt := range pf.Or {
+		plan.Or = append(plan.Or, planGroup(alt, requires, provides, external))
+	}
+	return writeTOML(plan, planPath)
+}
+
+func planGroup(group packfile.Plan, requires []link.Require, provides []planProvide, external map[string][]link.Require) planSections {
+	var out planSections
+	for _, req := range requires {
+		if len(group.Layers) == 0 || contains(group.Layers, req.Name) {
+			out.Requires = append(out.Requires, req)
+		}
+	}
+	for _, prov := range provides {
+		if len(group.Layers) == 0 || contains(group.Layers, prov.Name) {
+			out.Requires = append(out.Requires, external[prov.Name]...)
+		}
+	}
+	for _, prov := range provides {
+		if len(group.Layers) == 0 || contains(group.Layers, prov.Name) {
+			out.Provides = append(out.Provides, prov)
+		}
+	}
+	for _, req := range group.Requires {
+		out.Requires = append(out.Requires, link.Require{
+			Name:     req.Name,
+			Version:  req.Version,
+			Metadata: req.Metadata,
+		})
+	}
+	for _, prov := range group.Provides {
+		out.Provides = append(out.Provides, planProvide{Name: prov.Name})
+	}
+	return out
 }
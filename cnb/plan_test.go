@@ -0,0 +1,83 @@
+package cnb
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/BurntSushi/toml"
+	"github.com/buildpacks/lifecycle"
+
+	"github.com/sclevine/packfile"
+	"github.com/sclevine/packfile/link"
+)
+
+// buildpack plan as written by the lifecycle for buildpack API 0.2: only entries provided by this buildpack
+const testBuildPlan = `
+[[entries]]
+  name = "node"
+  version = "12.x"
+  [entries.metadata]
+    build = true
+
+[[entries]]
+  name = "node"
+  version = "12.16.1"
+  [entries.metadata]
+    launch = true
+
+[[entries]]
+  name = "npm"
+`
+
+// TestBuildPlan checks that each layer receives only its own entries from a lifecycle plan
+func TestBuildPlan(t *testing.T) {
+	var plan buildPlan
+	if _, err := toml.Decode(testBuildPlan, &plan); err != nil {
+		t.Fatal(err)
+	}
+	node := plan.get("node")
+	if len(node) != 2 ||
+		node[0].Version != "12.x" || node[0].Metadata["build"] != true ||
+		node[1].Version != "12.16.1" || node[1].Metadata["launch"] != true {
+		t.Errorf("unexpected node entries: %#v", node)
+	}
+	if npm := plan.get("npm"); len(npm) != 1 || npm[0].Version != "" {
+		t.Errorf("unexpected npm entries: %#v", npm)
+	}
+	if yarn := plan.get("yarn"); len(yarn) != 0 {
+		t.Errorf("unexpected yarn entries: %#v", yarn)
+	}
+}
+
+// TestPlanGroupExternal checks that requires on entries of other buildpacks are written in the shape the lifecycle reads
+func TestPlanGroupExternal(t *testing.T) {
+	group := packfile.Plan{Layers: []string{"modules"}}
+	requires := []link.Require{{Name: "modules", Metadata: map[string]interface{}{}}}
+	provides := []planProvide{{Name: "modules"}, {Name: "cache"}}
+	external := map[string][]link.Require{
+		"modules": {{Name: "node", Version: "12.x", Metadata: map[string]interface{}{"build": true}}},
+		"cache":   {{Name: "yarn"}},
+	}
+	buf := &bytes.Buffer{}
+	if err := toml.NewEncoder(buf).Encode(planGroup(group, requires, provides, external)); err != nil {
+		t.Fatal(err)
+	}
+	var out struct {
+		Requires []lifecycle.Require `toml:"requires"`
+		Provides []lifecycle.Provide `toml:"provides"`
+	}
+	if _, err := toml.Decode(buf.String(), &out); err != nil {
+		t.Fatal(err)
+	}
+	expected := []lifecycle.Require{
+		{Name: "modules", Metadata: map[string]interface{}{}},
+		{Name: "node", Version: "12.x", Metadata: map[string]interface{}{"build": true}},
+	}
+	if !reflect.DeepEqual(out.Requires, expected) {
+		t.Errorf("unexpected requires: %#v", out.Requires)
+	}
+	if len(out.Provides) != 1 || out.Provides[0].Name != "modules" {
+		t.Errorf("unexpected provides: %#v", out.Provides)
+	}
+}
@@ -39,11 +39,8 @@ func eachDir(dir string, fn func(name string) error) error {
 	return nil
 }
 
-func shellOverride(exec packfile.Exec, shell string) packfile.Exec {
-	if exec.Shell == "" {
-		exec.Shell = shell
-	}
-	return exec
+func hasExec(exec packfile.Exec) bool {
+	return exec.Inline != "" || exec.Path != "" || len(exec.Command) > 0
 }
 
 type matchTest struct {
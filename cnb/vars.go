@@ -0,0 +1,51 @@
+package cnb
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/xerrors"
+
+	"github.com/sclevine/packfile"
+)
+
+// resolveVars returns values by var name and by env var name.
+// The env values are passed to every script, even when clear-env is set.
+func resolveVars(vars []packfile.Var, platformDir string) (values, env map[string]string, err error) {
+	values, env = map[string]string{}, map[string]string{}
+	for _, v := range vars {
+		value, ok, err := lookupVar(v, platformDir)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !ok {
+			value = v.Default
+		}
+		if len(v.Values) > 0 && !contains(v.Values, value) {
+			return nil, nil, xerrors.Errorf("invalid value '%s' for config var '%s' (allowed: %s)",
+				value, v.Name, strings.Join(v.Values, ", "))
+		}
+		values[v.Name] = value
+		if v.Env != "" {
+			env[v.Env] = value
+		}
+	}
+	return values, env, nil
+}
+
+func lookupVar(v packfile.Var, platformDir string) (value string, ok bool, err error) {
+	if v.Env == "" {
+		return "", false, nil
+	}
+	contents, err := ioutil.ReadFile(filepath.Join(platformDir, "env", v.Env))
+	if err == nil {
+		// platform env files are often written with a trailing newline (e.g., by echo)
+		return strings.TrimSuffix(string(contents), "\n"), true, nil
+	} else if !os.IsNotExist(err) {
+		return "", false, err
+	}
+	value, ok = os.LookupEnv(v.Env)
+	return value, ok, nil
+}
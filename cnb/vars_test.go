@@ -0,0 +1,48 @@
+package cnb
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/sclevine/packfile"
+)
+
+// TestResolveVars checks that config vars are read from platform env files, with the trailing newline removed
+func TestResolveVars(t *testing.T) {
+	platformDir, err := ioutil.TempDir("", "vars-test.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(platformDir)
+	if err := os.Mkdir(filepath.Join(platformDir, "env"), 0777); err != nil {
+		t.Fatal(err)
+	}
+	for name, value := range map[string]string{
+		"PF_TEST_MODE":  "production\n",
+		"PF_TEST_LINES": "a\nb\n\n",
+		"PF_TEST_RAW":   "raw",
+	} {
+		if err := ioutil.WriteFile(filepath.Join(platformDir, "env", name), []byte(value), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	values, env, err := resolveVars([]packfile.Var{
+		{Name: "mode", Env: "PF_TEST_MODE", Values: []string{"development", "production"}},
+		{Name: "lines", Env: "PF_TEST_LINES"},
+		{Name: "raw", Env: "PF_TEST_RAW"},
+		{Name: "default", Env: "PF_TEST_UNSET", Default: "value"},
+	}, platformDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{"mode": "production", "lines": "a\nb\n", "raw": "raw", "default": "value"}
+	if !reflect.DeepEqual(values, expected) {
+		t.Errorf("unexpected values: %#v", values)
+	}
+	if env["PF_TEST_MODE"] != "production" {
+		t.Errorf("unexpected env: %#v", env)
+	}
+}
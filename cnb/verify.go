@@ -0,0 +1,233 @@
+package cnb
+
+import (
+	"bytes"
+	"debug/elf"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"golang.org/x/xerrors"
+
+	"github.com/sclevine/packfile/layers"
+	"github.com/sclevine/packfile/link"
+	"github.com/sclevine/packfile/sync"
+)
+
+const (
+	verifyWarn = "warn"
+	verifyFail = "fail"
+)
+
+type verifyTarget struct {
+	kind string
+	path string
+}
+
+type verifyIssue struct {
+	layer  string
+	path   string
+	verb   string
+	target verifyTarget
+}
+
+func (i verifyIssue) String() string {
+	return fmt.Sprintf("layer '%s' file '%s' %s %s '%s'", i.layer, i.path, i.verb, i.target.kind, i.target.path)
+}
+
+// verifyLayers scans exported layers for references to paths that are not available after rebase
+func verifyLayers(mode, appDir string, linkLayers []link.Layer) error {
+	switch mode {
+	case "":
+		return nil
+	case verifyWarn, verifyFail:
+	default:
+		return xerrors.Errorf("invalid verify mode '%s'", mode)
+	}
+	targets := []verifyTarget{{"app dir", appDir}}
+	var exported []link.Info
+	for _, l := range linkLayers {
+		info := l.Info()
+		if _, ok := l.(*layers.Build); ok && sync.NodeError(l) == nil {
+			var lt struct {
+				Launch bool `toml:"launch"`
+			}
+			if _, err := toml.DecodeFile(info.Share.LayerDir+".toml", &lt); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			if lt.Launch {
+				exported = append(exported, info)
+				continue
+			}
+		}
+		targets = append(targets, verifyTarget{"non-exported layer", info.Share.LayerDir})
+	}
+	var issues []verifyIssue
+	for _, info := range exported {
+		found, err := verifyDir(info.Name, info.Share.LayerDir, targets)
+		if err != nil {
+			return err
+		}
+		issues = append(issues, found...)
+	}
+	for _, issue := range issues {
+		fmt.Printf("Warning: %s\n", issue)
+	}
+	if mode == verifyFail && len(issues) > 0 {
+		return xerrors.Errorf("rebase verification failed with %d issue(s)", len(issues))
+	}
+	return nil
+}
+
+func verifyDir(name, dir string, targets []verifyTarget) ([]verifyIssue, error) {
+	var issues []verifyIssue
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if os.IsNotExist(err) && path == dir {
+			return filepath.SkipDir
+		} else if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			dst, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			for _, t := range targets {
+				if hasPathPrefix(dst, t.path) {
+					issues = append(issues, verifyIssue{name, rel, "links into", t})
+				}
+			}
+			return nil
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		var remaining []verifyTarget
+		rpaths, _ := readRPaths(path)
+		for _, t := range targets {
+			if hasAnyPathPrefix(rpaths, t.path) {
+				issues = append(issues, verifyIssue{name, rel, "has rpath into", t})
+			} else {
+				remaining = append(remaining, t)
+			}
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		found, err := scanFor(f, remaining)
+		if err != nil {
+			return err
+		}
+		for _, t := range found {
+			issues = append(issues, verifyIssue{name, rel, "references", t})
+		}
+		return nil
+	})
+	return issues, err
+}
+
+func readRPaths(path string) ([]string, error) {
+	f, err := elf.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var out []string
+	for _, tag := range []elf.DynTag{elf.DT_RPATH, elf.DT_RUNPATH} {
+		values, err := f.DynString(tag)
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range values {
+			out = append(out, filepath.SplitList(v)...)
+		}
+	}
+	return out, nil
+}
+
+// scanFor searches a stream for paths to targets without reading it into memory at once
+func scanFor(r io.Reader, targets []verifyTarget) ([]verifyTarget, error) {
+	if len(targets) == 0 {
+		return nil, nil
+	}
+	overlap := 0
+	for _, t := range targets {
+		if len(t.path) >= overlap {
+			overlap = len(t.path) + 1
+		}
+	}
+	matched := make([]bool, len(targets))
+	chunk := make([]byte, 32*1024)
+	var buf []byte
+	for {
+		n, err := r.Read(chunk)
+		buf = append(buf, chunk[:n]...)
+		eof := err == io.EOF
+		if err != nil && !eof {
+			return nil, err
+		}
+		for i, t := range targets {
+			if !matched[i] && containsPath(buf, []byte(t.path), eof) {
+				matched[i] = true
+			}
+		}
+		if eof {
+			break
+		}
+		if len(buf) > overlap {
+			buf = append(buf[:0], buf[len(buf)-overlap:]...)
+		}
+	}
+	var out []verifyTarget
+	for i, t := range targets {
+		if matched[i] {
+			out = append(out, t)
+		}
+	}
+	return out, nil
+}
+
+// containsPath only matches whole path components, so that /layers/a does not match /layers/ab
+func containsPath(buf, path []byte, eof bool) bool {
+	for off := 0; ; {
+		i := bytes.Index(buf[off:], path)
+		if i < 0 {
+			return false
+		}
+		end := off + i + len(path)
+		if end == len(buf) {
+			return eof
+		}
+		if !isPathChar(buf[end]) {
+			return true
+		}
+		off += i + 1
+	}
+}
+
+func isPathChar(b byte) bool {
+	return b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z' || b >= '0' && b <= '9' ||
+		b == '.' || b == '_' || b == '-'
+}
+
+func hasAnyPathPrefix(paths []string, prefix string) bool {
+	for _, p := range paths {
+		if hasPathPrefix(p, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func hasPathPrefix(path, prefix string) bool {
+	return path == prefix || strings.HasPrefix(path, prefix+string(filepath.Separator))
+}
@@ -0,0 +1,93 @@
+package cnb
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/sclevine/packfile"
+)
+
+type conditions struct {
+	StackID     string
+	PlatformDir string
+	AppDir      string
+}
+
+func newConditions(platformDir, appDir string) conditions {
+	return conditions{
+		StackID:     os.Getenv("CNB_STACK_ID"),
+		PlatformDir: platformDir,
+		AppDir:      appDir,
+	}
+}
+
+func (c conditions) match(when *packfile.When, md map[string]interface{}) (bool, error) {
+	if when == nil {
+		return true, nil
+	}
+	if len(when.Stacks) > 0 && !contains(when.Stacks, c.StackID) {
+		return false, nil
+	}
+	for _, name := range when.Env {
+		if _, err := os.Stat(filepath.Join(c.PlatformDir, "env", name)); os.IsNotExist(err) {
+			return false, nil
+		} else if err != nil {
+			return false, err
+		}
+	}
+	if len(when.Match) > 0 {
+		if ok, err := c.matchApp(when.Match); err != nil || !ok {
+			return false, err
+		}
+	}
+	if when.Metadata != "" {
+		tmpl, err := template.New("when").Parse(when.Metadata)
+		if err != nil {
+			return false, err
+		}
+		out := &bytes.Buffer{}
+		if err := tmpl.Execute(out, md); err != nil {
+			return false, err
+		}
+		if strings.TrimSpace(out.String()) != "true" {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func (c conditions) matchApp(globs []string) (bool, error) {
+	for _, glob := range globs {
+		matches, err := filepath.Glob(filepath.Join(c.AppDir, glob))
+		if err != nil {
+			return false, err
+		}
+		if len(matches) > 0 {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func layerMetadata(layer *packfile.Layer) map[string]interface{} {
+	md := map[string]interface{}{}
+	for k, v := range layer.Metadata {
+		md[k] = v
+	}
+	if layer.Version != "" {
+		md["version"] = layer.Version
+	}
+	return md
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -10,6 +10,8 @@ type Packfile struct {
 	Layers    []Layer   `toml:"layers" yaml:"layers"`
 	Slices    []Slice   `toml:"slices" yaml:"slices"`
 	Stacks    []Stack   `toml:"stacks" yaml:"stacks"`
+	Plan      Plan      `toml:"plan" yaml:"plan"`
+	Or        []Plan    `toml:"or" yaml:"or"`
 }
 
 type Config struct {
@@ -17,6 +19,16 @@ type Config struct {
 	Version string `toml:"version" yaml:"version"`
 	Name    string `toml:"name" yaml:"name"`
 	Shell   string `toml:"shell" yaml:"shell"`
+	Verify  string `toml:"verify" yaml:"verify"`
+	Vars    []Var  `toml:"vars" yaml:"vars"`
+}
+
+type Var struct {
+	Name        string   `toml:"name" yaml:"name"`
+	Description string   `toml:"description,omitempty" yaml:"description,omitempty"`
+	Default     string   `toml:"default,omitempty" yaml:"default,omitempty"`
+	Values      []string `toml:"values,omitempty" yaml:"values,omitempty"`
+	Env         string   `toml:"env,omitempty" yaml:"env,omitempty"`
 }
 
 type Process struct {
@@ -26,8 +38,25 @@ type Process struct {
 	Direct  bool     `toml:"direct" yaml:"direct"`
 }
 
+type Plan struct {
+	Layers   []string      `toml:"layers" yaml:"layers"`
+	Provides []PlanProvide `toml:"provides" yaml:"provides"`
+	Requires []PlanRequire `toml:"requires" yaml:"requires"`
+}
+
+type PlanProvide struct {
+	Name string `toml:"name" yaml:"name"`
+}
+
+type PlanRequire struct {
+	Name     string                 `toml:"name" yaml:"name"`
+	Version  string                 `toml:"version" yaml:"version"`
+	Metadata map[string]interface{} `toml:"metadata" yaml:"metadata"`
+}
+
 type Slice struct {
-	Paths []string `toml:"paths" yaml:"paths"`
+	Paths   []string `toml:"paths" yaml:"paths"`
+	Exclude []string `toml:"exclude,omitempty" yaml:"exclude,omitempty"`
 }
 
 type Stack struct {
@@ -36,8 +65,13 @@ type Stack struct {
 }
 
 type Cache struct {
-	Name  string `toml:"name" yaml:"name"`
-	Setup *Setup `toml:"setup" yaml:"setup"`
+	Name         string   `toml:"name" yaml:"name"`
+	MaxAge       string   `toml:"max-age,omitempty" yaml:"maxAge,omitempty"`
+	MaxSize      string   `toml:"max-size,omitempty" yaml:"maxSize,omitempty"`
+	InvalidateOn []string `toml:"invalidate-on,omitempty" yaml:"invalidateOn,omitempty"`
+	When         *When    `toml:"when" yaml:"when"`
+	Setup        *Setup   `toml:"setup" yaml:"setup"`
+	Prune        *Setup   `toml:"prune" yaml:"prune"`
 }
 
 type Setup struct {
@@ -46,15 +80,21 @@ type Setup struct {
 }
 
 type Layer struct {
-	Name     string                 `toml:"name" yaml:"name"`
-	Export   bool                   `toml:"export" yaml:"export"`
-	Expose   bool                   `toml:"expose" yaml:"expose"`
-	Store    bool                   `toml:"store" yaml:"store"`
-	Version  string                 `toml:"version" yaml:"version"`
-	Metadata map[string]interface{} `toml:"metadata" yaml:"metadata"`
-	Require  *Require               `toml:"require" yaml:"require"`
-	Provide  *Provide               `toml:"provide" yaml:"provide"`
-	Build    *Provide               `toml:"build" yaml:"build"`
+	Name        string                 `toml:"name" yaml:"name"`
+	Export      bool                   `toml:"export" yaml:"export"`
+	Expose      bool                   `toml:"expose" yaml:"expose"`
+	Store       bool                   `toml:"store" yaml:"store"`
+	ClearEnv    bool                   `toml:"clear-env" yaml:"clearEnv"`
+	Incremental bool                   `toml:"incremental" yaml:"incremental"`
+	Restore     bool                   `toml:"restore" yaml:"restore"`
+	Version     string                 `toml:"version" yaml:"version"`
+	Metadata    map[string]interface{} `toml:"metadata" yaml:"metadata"`
+	RebuildOn   []string               `toml:"rebuild-on" yaml:"rebuildOn"`
+	When        *When                  `toml:"when" yaml:"when"`
+	Require     *Require               `toml:"require" yaml:"require"`
+	Provide     *Provide               `toml:"provide" yaml:"provide"`
+	Build       *Provide               `toml:"build" yaml:"build"`
+	Retry       *Retry                 `toml:"retry" yaml:"retry"`
 }
 
 func (l *Layer) FindProvide() *Provide {
@@ -64,25 +104,43 @@ func (l *Layer) FindProvide() *Provide {
 	return l.Build
 }
 
+type When struct {
+	Stacks   []string `toml:"stacks" yaml:"stacks"`
+	Env      []string `toml:"env" yaml:"env"`
+	Match    []string `toml:"match" yaml:"match"`
+	Metadata string   `toml:"metadata" yaml:"metadata"`
+}
+
+type Retry struct {
+	Attempts int    `toml:"attempts" yaml:"attempts"`
+	Backoff  string `toml:"backoff" yaml:"backoff"`
+	Codes    []int  `toml:"codes" yaml:"codes"`
+}
+
 type Require struct {
 	Exec   `yaml:",inline"`
 	Runner RequireRunner `toml:"-" yaml:"-"`
 }
 
 type Provide struct {
-	LockApp bool   `toml:"lock-app" yaml:"lockApp"`
-	Test    *Test  `toml:"test" yaml:"test"`
-	Run     *Run   `toml:"run" yaml:"run"`
-	Links   []Link `toml:"links" yaml:"links"`
-	Deps    []Dep  `toml:"deps" yaml:"deps"`
-	Env     Envs   `toml:"env" yaml:"env"`
-	Profile []File `toml:"profile" yaml:"profile"`
+	LockApp   bool          `toml:"lock-app" yaml:"lockApp"`
+	Test      *Test         `toml:"test" yaml:"test"`
+	Run       *Run          `toml:"run" yaml:"run"`
+	Links     []Link        `toml:"links" yaml:"links"`
+	Deps      []Dep         `toml:"deps" yaml:"deps"`
+	Env       Envs          `toml:"env" yaml:"env"`
+	Profile   []File        `toml:"profile" yaml:"profile"`
+	Processes []Process     `toml:"processes" yaml:"processes"`
+	Slices    []Slice       `toml:"slices" yaml:"slices"`
+	AutoSlice []string      `toml:"auto-slice" yaml:"autoSlice"`
+	Requires  []PlanRequire `toml:"requires" yaml:"requires"`
 }
 
 type Exec struct {
-	Shell  string `toml:"shell" yaml:"shell"`
-	Inline string `toml:"inline" yaml:"inline"`
-	Path   string `toml:"path" yaml:"path"`
+	Shell   string   `toml:"shell" yaml:"shell"`
+	Inline  string   `toml:"inline" yaml:"inline"`
+	Path    string   `toml:"path" yaml:"path"`
+	Command []string `toml:"command" yaml:"command"`
 }
 
 type Run struct {
@@ -99,6 +157,7 @@ type Test struct {
 
 type Link struct {
 	Name        string `toml:"name" yaml:"name"`
+	Buildpack   string `toml:"buildpack" yaml:"buildpack"`
 	PathEnv     string `toml:"path-as" yaml:"pathAs"`
 	VersionEnv  string `toml:"version-as" yaml:"versionAs"`
 	MetadataEnv string `toml:"metadata-as" yaml:"metadataAs"`
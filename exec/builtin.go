@@ -0,0 +1,165 @@
+package exec
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"go.starlark.net/starlark"
+	"golang.org/x/xerrors"
+	"mvdan.cc/sh/v3/expand"
+	"mvdan.cc/sh/v3/interp"
+	"mvdan.cc/sh/v3/syntax"
+
+	"github.com/sclevine/packfile"
+)
+
+const builtinPrefix = "builtin:"
+
+func isBuiltin(shell string) bool {
+	return strings.HasPrefix(shell, builtinPrefix)
+}
+
+func (e *Exec) runBuiltin(st packfile.Streamer, env packfile.EnvMap) error {
+	if e.Inline != "" && e.Path != "" {
+		return xerrors.New("only one of inline, path, or command may be specified")
+	}
+	name, src := "inline", []byte(e.Inline)
+	if e.Path != "" {
+		name = filepath.Join(e.CtxDir, e.Path)
+		var err error
+		if src, err = ioutil.ReadFile(name); err != nil {
+			return err
+		}
+	} else if e.Inline == "" {
+		return xerrors.New("missing executable")
+	}
+	switch lang := strings.TrimPrefix(e.shell(), builtinPrefix); lang {
+	case "sh":
+		return runSh(st, env, name, src)
+	case "starlark":
+		return runStarlark(st, env, name, src)
+	default:
+		return xerrors.Errorf("unknown builtin interpreter '%s'", lang)
+	}
+}
+
+func runSh(st packfile.Streamer, env packfile.EnvMap, name string, src []byte) error {
+	file, err := syntax.NewParser().Parse(bytes.NewReader(src), name)
+	if err != nil {
+		return err
+	}
+	runner, err := interp.New(
+		interp.Env(expand.ListEnviron(env.Environ()...)),
+		interp.Dir(env["APP"]),
+		interp.StdIO(nil, st.Stdout(), st.Stderr()),
+	)
+	if err != nil {
+		return err
+	}
+	if err := runner.Run(context.Background(), file); err != nil {
+		if code, ok := interp.IsExitStatus(err); ok {
+			return CodeError(code)
+		}
+		return err
+	}
+	return nil
+}
+
+// runStarlark provides scripts with an env dict and a minimal set of file helpers.
+// Relative paths are resolved against the app directory.
+func runStarlark(st packfile.Streamer, env packfile.EnvMap, name string, src []byte) error {
+	envDict := starlark.NewDict(len(env))
+	keys := make([]string, 0, len(env))
+	for k := range env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if err := envDict.SetKey(starlark.String(k), starlark.String(env[k])); err != nil {
+			return err
+		}
+	}
+	resolve := func(path string) string {
+		if filepath.IsAbs(path) {
+			return path
+		}
+		return filepath.Join(env["APP"], path)
+	}
+	var code *CodeError
+	predeclared := starlark.StringDict{
+		"env": envDict,
+		"read_file": starlark.NewBuiltin("read_file", func(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			var path string
+			if err := starlark.UnpackArgs(b.Name(), args, kwargs, "path", &path); err != nil {
+				return nil, err
+			}
+			out, err := ioutil.ReadFile(resolve(path))
+			if err != nil {
+				return nil, err
+			}
+			return starlark.String(out), nil
+		}),
+		"write_file": starlark.NewBuiltin("write_file", func(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			var path, content string
+			if err := starlark.UnpackArgs(b.Name(), args, kwargs, "path", &path, "content", &content); err != nil {
+				return nil, err
+			}
+			return starlark.None, ioutil.WriteFile(resolve(path), []byte(content), 0666)
+		}),
+		"mkdir": starlark.NewBuiltin("mkdir", func(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			var path string
+			if err := starlark.UnpackArgs(b.Name(), args, kwargs, "path", &path); err != nil {
+				return nil, err
+			}
+			return starlark.None, os.MkdirAll(resolve(path), 0777)
+		}),
+		"exists": starlark.NewBuiltin("exists", func(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			var path string
+			if err := starlark.UnpackArgs(b.Name(), args, kwargs, "path", &path); err != nil {
+				return nil, err
+			}
+			_, err := os.Stat(resolve(path))
+			if os.IsNotExist(err) {
+				return starlark.False, nil
+			} else if err != nil {
+				return nil, err
+			}
+			return starlark.True, nil
+		}),
+		"exit": starlark.NewBuiltin("exit", func(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			var n int
+			if err := starlark.UnpackArgs(b.Name(), args, kwargs, "code", &n); err != nil {
+				return nil, err
+			}
+			c := CodeError(n)
+			code = &c
+			return nil, c
+		}),
+	}
+	thread := &starlark.Thread{
+		Name: name,
+		Print: func(_ *starlark.Thread, msg string) {
+			fmt.Fprintln(st.Stdout(), msg)
+		},
+	}
+	if _, err := starlark.ExecFile(thread, name, src, predeclared); err != nil {
+		if code != nil {
+			if *code == 0 {
+				return nil
+			}
+			return *code
+		}
+		if err, ok := err.(*starlark.EvalError); ok {
+			fmt.Fprintln(st.Stderr(), err.Backtrace())
+			return CodeError(1)
+		}
+		return err
+	}
+	return nil
+}
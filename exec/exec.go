@@ -1,7 +1,9 @@
 package exec
 
 import (
+	"bufio"
 	"crypto/sha256"
+	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -15,6 +17,7 @@ import (
 	"golang.org/x/xerrors"
 
 	"github.com/sclevine/packfile"
+	"github.com/sclevine/packfile/metadata"
 )
 
 type CodeError int
@@ -41,17 +44,28 @@ func IsError(err error) bool {
 
 type Exec struct {
 	packfile.Exec
-	Name   string
-	CtxDir string
+	Name         string
+	CtxDir       string
+	DefaultShell string
 }
 
 func (e *Exec) Version() string {
 	hash := sha256.New()
-	writeField(hash, e.Shell, e.Inline)
+	writeField(hash, e.shell(), e.Inline)
 	writeFile(hash, e.Path)
+	if len(e.Command) > 0 {
+		writeField(hash, e.Command)
+	}
 	return fmt.Sprintf("%x", hash.Sum(nil))
 }
 
+func (e *Exec) shell() string {
+	if e.Shell != "" {
+		return e.Shell
+	}
+	return e.DefaultShell
+}
+
 func writeField(out io.Writer, values ...interface{}) {
 	for _, v := range values {
 		fmt.Fprintln(out, v)
@@ -70,20 +84,21 @@ func writeFile(out io.Writer, path string) {
 }
 
 func (e *Exec) Test(st packfile.Streamer, env packfile.EnvMap, md packfile.Metadata) error {
-	mddir, ok := md.(interface{ Dir() string })
-	if !ok {
-		return xerrors.New("metadata directory not available")
+	export, err := setMetadataDir(env, md)
+	if err != nil {
+		return err
 	}
-	env["MD"] = mddir.Dir()
-	return e.run(st, env)
+	defer export.Close()
+	return e.runExported(st, env, export)
 }
 
 func (e *Exec) Provide(st packfile.Streamer, env packfile.EnvMap, md packfile.Metadata, deps []packfile.Dep) error {
-	mddir, ok := md.(interface{ Dir() string })
-	if !ok {
-		return xerrors.New("metadata directory not available")
+	export, err := setMetadataDir(env, md)
+	if err != nil {
+		return err
 	}
-	env["MD"] = mddir.Dir()
+	defer export.Close()
+	setLaunchDir(env, md)
 
 	tmpDir, err := ioutil.TempDir("", "packfile.deps."+e.Name)
 	if err != nil {
@@ -98,30 +113,130 @@ func (e *Exec) Provide(st packfile.Streamer, env packfile.EnvMap, md packfile.Me
 	if err := writeTOML(packfile.ConfigTOML{
 		ContextDir:  e.CtxDir,
 		StoreDir:    storeDir,
-		MetadataDir: mddir.Dir(),
+		MetadataDir: env["MD"],
 		Deps:        deps,
 	}, configPath); err != nil {
 		return err
 	}
 	env["PF_CONFIG_PATH"] = configPath
-	return e.run(st, env)
+	return e.runExported(st, env, export)
 }
 
 func (e *Exec) Require(st packfile.Streamer, env packfile.EnvMap, md packfile.Metadata) error {
-	mddir, ok := md.(interface{ Dir() string })
+	export, err := setMetadataDir(env, md)
+	if err != nil {
+		return err
+	}
+	defer export.Close()
+	setLaunchDir(env, md)
+	return e.runExported(st, env, export)
+}
+
+// setMetadataDir also exports all metadata as a single JSON file at MD_JSON.
+// Structured values may be written back as $MD/<key>.json.
+// Metadata that is not stored in a directory (e.g., from a Go runner) is exported to a temporary directory.
+func setMetadataDir(env packfile.EnvMap, md packfile.Metadata) (*mdExport, error) {
+	all, err := md.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	export := &mdExport{md: md}
+	if mddir, ok := md.(interface{ Dir() string }); ok && mddir.Dir() != "" {
+		env["MD"] = mddir.Dir()
+	} else {
+		if export.dir, err = ioutil.TempDir("", "packfile.md."); err != nil {
+			return nil, err
+		}
+		if err := metadata.NewFS(export.dir).WriteAll(all); err != nil {
+			export.Close()
+			return nil, err
+		}
+		env["MD"] = export.dir
+	}
+	f, err := ioutil.TempFile("", "packfile.md.")
+	if err != nil {
+		export.Close()
+		return nil, err
+	}
+	defer f.Close()
+	export.json = f.Name()
+	if err := json.NewEncoder(f).Encode(all); err != nil {
+		export.Close()
+		return nil, err
+	}
+	env["MD_JSON"] = f.Name()
+	return export, nil
+}
+
+// mdExport holds the files that expose metadata to a script
+type mdExport struct {
+	md   packfile.Metadata
+	dir  string
+	json string
+}
+
+// sync copies metadata written by the script back when it was exported to a temporary directory
+func (m *mdExport) sync() error {
+	if m.dir == "" {
+		return nil
+	}
+	values, err := metadata.NewFS(m.dir).ReadAll()
+	if err != nil {
+		return err
+	}
+	if err := m.md.DeleteAll(); err != nil {
+		return err
+	}
+	return m.md.WriteAll(values)
+}
+
+func (m *mdExport) Close() error {
+	if m.json != "" {
+		os.Remove(m.json)
+	}
+	if m.dir != "" {
+		return os.RemoveAll(m.dir)
+	}
+	return nil
+}
+
+// runExported runs the script, then syncs exported metadata even if the script failed, as writes to $MD are not transactional
+func (e *Exec) runExported(st packfile.Streamer, env packfile.EnvMap, export *mdExport) error {
+	err := e.run(st, env)
+	if serr := export.sync(); err == nil {
+		err = serr
+	}
+	return err
+}
+
+func setLaunchDir(env packfile.EnvMap, md packfile.Metadata) {
+	lmd, ok := md.(packfile.LaunchMetadata)
 	if !ok {
-		return xerrors.New("metadata directory not available")
+		return
+	}
+	if launch, ok := lmd.Launch().(interface{ Dir() string }); ok {
+		env["LAUNCH"] = launch.Dir()
 	}
-	env["MD"] = mddir.Dir()
-	return e.run(st, env)
 }
 
 func (e *Exec) Setup(st packfile.Streamer, env packfile.EnvMap) error {
 	return e.run(st, env)
 }
 
+func (e *Exec) SetupMetadata(st packfile.Streamer, env packfile.EnvMap, md packfile.Metadata) error {
+	export, err := setMetadataDir(env, md)
+	if err != nil {
+		return err
+	}
+	defer export.Close()
+	return e.runExported(st, env, export)
+}
+
 func (e *Exec) run(st packfile.Streamer, env packfile.EnvMap) error {
-	cmd, c, err := execCmd(&e.Exec, e.CtxDir)
+	if len(e.Command) == 0 && isBuiltin(e.shell()) {
+		return e.runBuiltin(st, env)
+	}
+	cmd, c, err := execCmd(e, env)
 	if err != nil {
 		return err
 	}
@@ -140,21 +255,27 @@ func (e *Exec) run(st packfile.Streamer, env packfile.EnvMap) error {
 	return nil
 }
 
-// NOTE: implements UNIX exec-style shebang parsing for shell
-func execCmd(e *packfile.Exec, ctxDir string) (*exec.Cmd, io.Closer, error) {
-	if e.Inline != "" && e.Path != "" {
-		return nil, nil, xerrors.New("both inline and path specified")
+func execCmd(e *Exec, env packfile.EnvMap) (*exec.Cmd, io.Closer, error) {
+	n := 0
+	for _, set := range []bool{e.Inline != "", e.Path != "", len(e.Command) > 0} {
+		if set {
+			n++
+		}
 	}
-	shell := e.Shell
-	parts := strings.SplitN(shell, " ", 2)
-	if len(parts) == 0 {
-		return nil, nil, xerrors.New("missing shell")
+	if n > 1 {
+		return nil, nil, xerrors.New("only one of inline, path, or command may be specified")
 	}
-	var args []string
-	if len(parts) > 1 {
-		shell = parts[0]
-		args = append(args, parts[1])
+	if len(e.Command) > 0 {
+		name, err := lookPath(e.Command[0], e.CtxDir, env["PATH"])
+		if err != nil {
+			return nil, nil, err
+		}
+		return exec.Command(name, e.Command[1:]...), nopCloser{}, nil
 	}
+
+	var script string
+	var closer io.Closer = nopCloser{}
+	shell := strings.Fields(e.shell())
 	if e.Inline != "" {
 		f, err := ioutil.TempFile("", "packfile.")
 		if err != nil {
@@ -164,14 +285,79 @@ func execCmd(e *packfile.Exec, ctxDir string) (*exec.Cmd, io.Closer, error) {
 		if _, err := f.WriteString(e.Inline); err != nil {
 			return nil, nil, err
 		}
-		return exec.Command(shell, append(args, f.Name())...), rmCloser{f.Name()}, nil
+		script, closer = f.Name(), rmCloser{f.Name()}
+	} else if e.Path != "" {
+		script = filepath.Join(e.CtxDir, e.Path)
+		if e.Shell == "" {
+			interp, err := readShebang(script)
+			if err != nil {
+				return nil, nil, err
+			}
+			if interp != nil {
+				shell = interp
+			}
+		}
+	} else {
+		return nil, nil, xerrors.New("missing executable")
+	}
+	if len(shell) == 0 {
+		closer.Close()
+		return nil, nil, xerrors.New("missing shell")
+	}
+	name, err := lookPath(shell[0], e.CtxDir, env["PATH"])
+	if err != nil {
+		closer.Close()
+		return nil, nil, err
 	}
+	return exec.Command(name, append(shell[1:], script)...), closer, nil
+}
 
-	if e.Path == "" {
-		return nil, nil, xerrors.New("missing executable")
+// NOTE: implements UNIX exec-style shebang parsing, where everything after the interpreter is a single argument
+func readShebang(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	line, err := bufio.NewReader(f).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return nil, err
 	}
+	if !strings.HasPrefix(line, "#!") {
+		return nil, nil
+	}
+	line = strings.TrimSpace(line[2:])
+	if line == "" {
+		return nil, nil
+	}
+	if i := strings.IndexAny(line, " \t"); i >= 0 {
+		if arg := strings.TrimSpace(line[i:]); arg != "" {
+			return []string{line[:i], arg}, nil
+		}
+		return []string{line[:i]}, nil
+	}
+	return []string{line}, nil
+}
 
-	return exec.Command(shell, append(args, filepath.Join(ctxDir, e.Path))...), nopCloser{}, nil
+// lookPath resolves executables using PATH from the provided environment instead of the current process.
+// Relative paths are resolved against the context directory.
+func lookPath(name, ctxDir, path string) (string, error) {
+	if strings.Contains(name, "/") {
+		if !filepath.IsAbs(name) {
+			return filepath.Join(ctxDir, name), nil
+		}
+		return name, nil
+	}
+	if path == "" {
+		return exec.LookPath(name)
+	}
+	for _, dir := range filepath.SplitList(path) {
+		p := filepath.Join(dir, name)
+		if fi, err := os.Stat(p); err == nil && !fi.IsDir() && fi.Mode()&0111 != 0 {
+			return p, nil
+		}
+	}
+	return "", xerrors.Errorf("executable '%s' not found in PATH", name)
 }
 
 type rmCloser struct{ path string }
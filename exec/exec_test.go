@@ -0,0 +1,52 @@
+package exec_test
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/sclevine/packfile"
+	"github.com/sclevine/packfile/exec"
+	"github.com/sclevine/packfile/metadata"
+)
+
+type streamer struct{}
+
+func (streamer) Stdout() io.Writer { return ioutil.Discard }
+func (streamer) Stderr() io.Writer { return ioutil.Discard }
+
+type memoryMetadata struct {
+	metadata.Metadata
+}
+
+func (memoryMetadata) Link(string) metadata.Metadata { return nil }
+
+// TestSetupMetadataMemory checks that metadata without a directory is exported to scripts and copied back
+func TestSetupMetadataMemory(t *testing.T) {
+	md := memoryMetadata{metadata.NewMemory()}
+	if err := md.WriteAll(map[string]interface{}{
+		"old":  "value",
+		"keep": map[string]interface{}{"a": "1"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	e := &exec.Exec{
+		Exec: packfile.Exec{
+			Shell:  "builtin:sh",
+			Inline: `[ "$(cat "$MD/keep/a")" = 1 ] || exit 1; printf 2 > "$MD/new"; rm "$MD/old"`,
+		},
+		Name: "test",
+	}
+	var _ packfile.SetupMetadataRunner = e
+	if err := e.SetupMetadata(streamer{}, packfile.EnvMap{"PATH": os.Getenv("PATH")}, md); err != nil {
+		t.Fatal(err)
+	}
+	all, err := md.ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 2 || all["new"] != "2" || all["keep"].(map[string]interface{})["a"] != "1" {
+		t.Errorf("unexpected metadata: %#v", all)
+	}
+}
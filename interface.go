@@ -12,6 +12,12 @@ type SetupRunner interface {
 	Version() string
 }
 
+// SetupMetadataRunner is implemented by setup runners that read or write cache metadata
+type SetupMetadataRunner interface {
+	SetupRunner
+	SetupMetadata(st Streamer, env EnvMap, md Metadata) error
+}
+
 type RequireRunner interface {
 	Require(st Streamer, env EnvMap, md Metadata) error
 }
@@ -35,6 +41,12 @@ type Metadata interface {
 	Link(as string) metadata.Metadata
 }
 
+// LaunchMetadata is implemented by the Metadata passed to runners that may contribute to launch.toml
+type LaunchMetadata interface {
+	Metadata
+	Launch() metadata.Metadata
+}
+
 type EnvMap map[string]string
 
 func (e EnvMap) Environ() []string {
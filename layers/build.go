@@ -1,7 +1,6 @@
 package layers
 
 import (
-	"bytes"
 	"crypto/sha256"
 	"fmt"
 	"io"
@@ -9,7 +8,6 @@ import (
 	"math"
 	"os"
 	"path/filepath"
-	"text/template"
 
 	"github.com/BurntSushi/toml"
 	lcenv "github.com/buildpacks/lifecycle/env"
@@ -28,12 +26,19 @@ type Build struct {
 	Layer         *packfile.Layer
 	ProvideRunner packfile.ProvideRunner
 	TestRunner    packfile.TestRunner
+	Launch        metadata.Metadata
 	Requires      []link.Require
 	AppDir        string
+	PlatformDir   string
+	Vars          map[string]string
+	VarEnv        map[string]string
 	BuildID       string
 	LastBuildID   string
+	RestoreDir    string
 	links         []linkInfo
 	syncs         []sync.Link
+	requireLaunch metadata.Metadata
+	previous      string
 }
 
 func (l *Build) Info() link.Info {
@@ -51,12 +56,19 @@ func (l *Build) Locks(_ link.Layer) bool {
 
 func (l *Build) Backward(targets []link.Layer) {
 	from := l.Info()
+	for _, lnk := range from.Links {
+		if lnk.Buildpack != "" {
+			l.links = append(l.links, linkInfo{lnk, &link.Share{
+				LayerDir: externalLayerDir(l.LayerDir, lnk),
+			}, false})
+		}
+	}
 	for i := range targets {
 		to := targets[i].Info()
 
 		for _, link := range from.Links {
-			if link.Name == to.Name {
-				l.links = append(l.links, linkInfo{link, to.Share})
+			if link.Name == to.Name && link.Buildpack == "" {
+				l.links = append(l.links, linkInfo{link, to.Share, to.Cache})
 				l.syncs = append(l.syncs, sync.NodeLink(targets[i], sync.LinkRequire))
 			}
 		}
@@ -81,7 +93,7 @@ func (l *Build) Forward(targets []link.Layer) {
 		to := targets[i].Info()
 
 		for _, link := range to.Links {
-			if link.Name == from.Name {
+			if link.Name == from.Name && link.Buildpack == "" {
 				t := sync.LinkNone
 				if link.LinkVersion {
 					t = sync.LinkVersion
@@ -137,6 +149,9 @@ func mergeRequire(md metadata.Metadata, req link.Require) error {
 	if err != nil {
 		prevBuild = "false"
 	}
+	if err := checkReservedAll(req.Metadata); err != nil {
+		return err
+	}
 	if err := md.DeleteAll(); err != nil {
 		return err
 	}
@@ -173,13 +188,21 @@ func padNum(n int) func(int) string {
 }
 
 func (l *Build) Test() (exists, matched bool, err error) {
+	if err := l.loadExternalLinks(); err != nil {
+		return false, false, err
+	}
 	if l.Layer.Require == nil {
 		if err := writeLayerMetadata(l.Metadata, l.Layer); err != nil {
 			return false, false, err
 		}
 	}
-	pad := padNum(len(l.Requires))
-	for i, req := range l.Requires {
+	requires, launch, err := splitRequireLaunch(l.Requires)
+	if err != nil {
+		return false, false, err
+	}
+	l.requireLaunch = launch
+	pad := padNum(len(requires))
+	for i, req := range requires {
 		if err := addRequire(l.Metadata, req, pad(i)); err != nil {
 			return false, false, err
 
@@ -188,9 +211,28 @@ func (l *Build) Test() (exists, matched bool, err error) {
 			return false, false, err
 		}
 	}
+	if version, err := mergeVersions(l.Layer.Name, requires); err != nil {
+		return false, false, err
+	} else if version != "" {
+		if err := l.Metadata.Write(version, "version"); err != nil {
+			return false, false, err
+		}
+	}
+	if err := writeVars(l.Metadata, l.Vars); err != nil {
+		return false, false, err
+	}
 
-	env := packfile.NewEnvMap(os.Environ())
-	md := newMetadataMap(l.Metadata)
+	env, err := newEnv(l.PlatformDir, l.Layer.ClearEnv, l.VarEnv)
+	if err != nil {
+		return false, false, err
+	}
+	// launch contributions from provide.test would be lost when the layer is skipped
+	md := newMetadataMap(l.Metadata, metadata.NewReadOnly(metadata.NewMemory()))
+	snapshotDir, err := ioutil.TempDir("", "packfile.links."+l.Layer.Name)
+	if err != nil {
+		return false, false, err
+	}
+	defer os.RemoveAll(snapshotDir)
 
 	for _, link := range l.links {
 		if l.fullEnv() && link.PathEnv != "" {
@@ -204,7 +246,9 @@ func (l *Build) Test() (exists, matched bool, err error) {
 			env[link.VersionEnv] = lt.Metadata.Version
 		}
 		if link.MetadataEnv != "" {
-			md.links[link.MetadataEnv] = link.Metadata
+			if env[link.MetadataEnv], err = md.addLink(link, snapshotDir); err != nil {
+				return false, false, err
+			}
 		}
 	}
 	if l.fullEnv() {
@@ -245,34 +289,158 @@ func (l *Build) Test() (exists, matched bool, err error) {
 	newDigest := l.digest()
 	layerTOML.Metadata.CodeDigest = newDigest
 
+	oldTested := layerTOML.Metadata.Tested
+	newTested, err := l.tested()
+	if err != nil {
+		return false, false, err
+	}
+	layerTOML.Metadata.Tested = newTested
+
 	if err := writeTOML(layerTOML, layerTOMLPath); err != nil {
 		return false, false, err
 	}
 
+	changes := metadata.Diff(oldTested, newTested)
+	if newVersion != oldVersion {
+		changes = append([]metadata.Change{{Key: "version", Old: oldVersion, New: newVersion}}, changes...)
+	}
+	if oldDigest != "" && len(changes) > 0 {
+		fmt.Fprintf(l.Stdout(), "Metadata changed for layer '%s':\n", l.Layer.Name)
+		for _, c := range changes {
+			fmt.Fprintf(l.Stdout(), "  %s\n", c)
+		}
+	}
+
 	if cachedBuildID != l.LastBuildID ||
 		newDigest != oldDigest ||
-		newVersion != oldVersion ||
+		len(changes) > 0 ||
 		l.provide().LockApp {
 		return false, false, nil
 	}
 	if _, err := os.Stat(l.LayerDir); xerrors.Is(err, os.ErrNotExist) {
+		if l.restoring() {
+			if ok, err := l.restore(layerTOML.Metadata.ContentDigest); err != nil {
+				return false, false, err
+			} else if ok {
+				fmt.Fprintf(l.Stdout(), "Restored layer '%s' from local cache.\n", l.Layer.Name)
+				return true, true, nil
+			}
+		}
 		return false, !l.Layer.Expose && !l.Layer.Store, nil
 	}
 	return true, true, nil
 }
 
+// tested returns the values of rebuild-on keys after provide.test, which are compared against the last build
+func (l *Build) tested() (map[string]interface{}, error) {
+	if len(l.Layer.RebuildOn) == 0 {
+		return nil, nil
+	}
+	md, err := l.Metadata.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	out := map[string]interface{}{}
+	for _, key := range l.Layer.RebuildOn {
+		if v, ok := metadata.Lookup(md, key); ok {
+			out[key] = v
+		}
+	}
+	return out, nil
+}
+
 func mdToBool(s string, err error) bool {
 	return err == nil && s == "true"
 }
 
 func (l *Build) Run() error {
 	fmt.Fprintf(l.Stdout(), "Building layer '%s'...\n", l.Layer.Name)
+	md := newMetadataMap(l.Metadata, l.Launch)
+	tmpDir, err := ioutil.TempDir(filepath.Dir(l.LayerDir), "."+l.Layer.Name+".previous.")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+	if l.Layer.Incremental {
+		if err := l.movePrevious(tmpDir); err != nil {
+			return err
+		}
+	}
+	reset, err := l.attemptReset(tmpDir)
+	if err != nil {
+		return err
+	}
+	if err := l.retry(func() error {
+		return l.runProvide(md)
+	}, reset); err != nil {
+		return err
+	}
+
+	layerTOMLPath := l.LayerDir + ".toml"
+	layerTOML, err := readLayerTOML(layerTOMLPath)
+	if err != nil {
+		return err
+	}
+	versionStr := "."
+	if v, err := md.Read("version"); err == nil {
+		versionStr = " with version: " + v
+	}
+	fmt.Fprintf(l.Stdout(), "Built layer '%s'%s\n", l.Layer.Name, versionStr)
+	saved, err := l.Metadata.ReadAll()
+	if err != nil {
+		return err
+	}
+	delete(saved, "launch")
+	delete(saved, "build")
+	layerTOML.Metadata.Saved = saved
+	if l.Launch != nil {
+		if layerTOML.Metadata.Launch, err = l.Launch.ReadAll(); err != nil {
+			return err
+		}
+	}
+	layerTOML.Metadata.ContentDigest = ""
+	if l.restoring() {
+		if layerTOML.Metadata.ContentDigest, err = contentDigest(l.LayerDir); err != nil {
+			return err
+		}
+		if err := l.saveRestore(layerTOML.Metadata.ContentDigest); err != nil {
+			return err
+		}
+	}
+	return writeTOML(layerTOML, layerTOMLPath)
+}
+
+// restoring is true for exported layers that are not stored, when restore is enabled
+func (l *Build) restoring() bool {
+	return l.Layer.Restore && l.Layer.Export && !l.Layer.Store && l.RestoreDir != ""
+}
+
+// movePrevious moves existing layer contents next to the layer dir, so that they can be reused without a copy
+func (l *Build) movePrevious(tmpDir string) error {
+	previous := filepath.Join(tmpDir, "layer")
+	if err := os.Rename(l.LayerDir, previous); os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	l.previous = previous
+	return nil
+}
+
+func (l *Build) runProvide(md metadataMap) error {
 	if err := os.RemoveAll(l.LayerDir); err != nil {
 		return err
 	}
 
-	env := packfile.NewEnvMap(os.Environ())
-	md := newMetadataMap(l.Metadata)
+	env, err := newEnv(l.PlatformDir, l.Layer.ClearEnv, l.VarEnv)
+	if err != nil {
+		return err
+	}
+	snapshotDir, err := ioutil.TempDir("", "packfile.links."+l.Layer.Name)
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(snapshotDir)
 
 	for _, link := range l.links {
 		if link.PathEnv != "" {
@@ -286,7 +454,9 @@ func (l *Build) Run() error {
 			env[link.VersionEnv] = lt.Metadata.Version
 		}
 		if link.MetadataEnv != "" {
-			md.links[link.MetadataEnv] = link.Metadata
+			if env[link.MetadataEnv], err = md.addLink(link, snapshotDir); err != nil {
+				return err
+			}
 		}
 	}
 	if err := setupLinkEnv(env, l.links); err != nil {
@@ -308,30 +478,13 @@ func (l *Build) Run() error {
 
 	env["APP"] = l.AppDir
 	env["LAYER"] = l.LayerDir
-	if l.ProvideRunner != nil {
-		if err := l.ProvideRunner.Provide(l.Streamer, env, md, deps); err != nil {
-			return err
-		}
-	}
-
-	layerTOMLPath := l.LayerDir + ".toml"
-	layerTOML, err := readLayerTOML(layerTOMLPath)
-	if err != nil {
-		return err
+	if l.previous != "" {
+		env["PREVIOUS_LAYER"] = l.previous
 	}
-	versionStr := "."
-	if v, err := md.Read("version"); err == nil {
-		versionStr = " with version: " + v
-	}
-	fmt.Fprintf(l.Stdout(), "Built layer '%s'%s\n", l.Layer.Name, versionStr)
-	saved, err := l.Metadata.ReadAll()
-	if err != nil {
-		return err
+	if l.ProvideRunner != nil {
+		return l.ProvideRunner.Provide(l.Streamer, env, md, deps)
 	}
-	delete(saved, "launch")
-	delete(saved, "build")
-	layerTOML.Metadata.Saved = saved
-	return writeTOML(layerTOML, layerTOMLPath)
+	return nil
 }
 
 func (l *Build) Skip() error {
@@ -346,13 +499,25 @@ func (l *Build) Skip() error {
 		return err
 	}
 	saved := layerTOML.Metadata.Saved
+	if saved == nil {
+		saved = map[string]interface{}{}
+	}
 	if layerTOML.Launch {
 		saved["launch"] = "true"
 	}
 	if layerTOML.Build {
 		saved["build"] = "true"
 	}
-	return l.Metadata.WriteAll(saved)
+	if err := l.Metadata.WriteAll(saved); err != nil {
+		return err
+	}
+	if l.Launch == nil {
+		return nil
+	}
+	if err := l.Launch.DeleteAll(); err != nil {
+		return err
+	}
+	return l.Launch.WriteAll(layerTOML.Metadata.Launch)
 }
 
 func (l *Build) digest() string {
@@ -383,9 +548,16 @@ func (l *Build) digest() string {
 		}
 	}
 	for _, link := range l.provide().Links {
-		writeField(hash, link.Name, link.PathEnv, link.VersionEnv, link.MetadataEnv)
+		writeField(hash, link.Name, link.Buildpack, link.PathEnv, link.VersionEnv, link.MetadataEnv)
 		fmt.Fprintf(hash, "%t\n%t\n", link.LinkContent, link.LinkVersion)
 	}
+	for _, link := range l.links {
+		if link.Buildpack != "" && (link.LinkContent || link.LinkVersion) {
+			if lt, err := readLayerTOML(link.layerTOML()); err == nil {
+				writeField(hash, lt.Metadata.Version)
+			}
+		}
+	}
 	return fmt.Sprintf("%x", hash.Sum(nil))
 }
 
@@ -408,10 +580,10 @@ func writeFile(out io.Writer, path string) {
 
 func (l *Build) envs() (packfile.Envs, error) {
 	out := packfile.Envs{}
-	vars := struct {
-		Layer string
-		App   string
-	}{l.LayerDir, l.AppDir}
+	vars, err := l.templateData()
+	if err != nil {
+		return out, err
+	}
 	for _, e := range l.provide().Env.Build {
 		var err error
 		e.Value, err = interpolate(e.Value, vars)
@@ -440,7 +612,7 @@ func (l *Build) envs() (packfile.Envs, error) {
 }
 
 func (l *Build) deps() ([]packfile.Dep, error) {
-	vars, err := l.Metadata.ReadAll()
+	vars, err := l.templateData()
 	if err != nil {
 		return nil, err
 	}
@@ -463,18 +635,6 @@ func (l *Build) deps() ([]packfile.Dep, error) {
 	return deps, nil
 }
 
-func interpolate(text string, vars interface{}) (string, error) {
-	tmpl, err := template.New("vars").Parse(text)
-	if err != nil {
-		return "", err
-	}
-	out := &bytes.Buffer{}
-	if err := tmpl.Execute(out, vars); err != nil {
-		return "", err
-	}
-	return out.String(), nil
-}
-
 func (l *Build) setupEnvs(env packfile.EnvMap) error {
 	envs, err := l.envs()
 	if err != nil {
@@ -527,6 +687,7 @@ func setupEnvDir(env []packfile.Env, path string) error {
 	return nil
 }
 
+// setupProfile templates inline profile scripts, while profile files are copied as-is
 func (l *Build) setupProfile() error {
 	profiles := l.provide().Profile
 	if len(profiles) == 0 {
@@ -540,10 +701,17 @@ func (l *Build) setupProfile() error {
 	for i, file := range profiles {
 		path := filepath.Join(profiled, pad(i)+".sh")
 		if file.Inline != "" {
-			err := ioutil.WriteFile(path, []byte(file.Inline), 0777)
+			vars, err := l.templateData()
+			if err != nil {
+				return err
+			}
+			script, err := interpolate(file.Inline, vars)
 			if err != nil {
 				return err
 			}
+			if err := ioutil.WriteFile(path, []byte(script), 0777); err != nil {
+				return err
+			}
 		} else if file.Path != "" {
 			if err := copyFileContents(path, file.Path); err != nil {
 				return err
@@ -582,10 +750,14 @@ type layerTOML struct {
 	Build    bool `toml:"build"`
 	Cache    bool `toml:"cache"`
 	Metadata struct {
-		Version    string                 `toml:"version,omitempty"`
-		BuildID    string                 `toml:"build-id,omitempty"`
-		CodeDigest string                 `toml:"code-digest"`
-		Saved      map[string]interface{} `toml:"saved,omitempty"`
+		Version       string                 `toml:"version,omitempty"`
+		BuildID       string                 `toml:"build-id,omitempty"`
+		CodeDigest    string                 `toml:"code-digest"`
+		Saved         map[string]interface{} `toml:"saved,omitempty"`
+		Tested        map[string]interface{} `toml:"tested,omitempty"`
+		Created       string                 `toml:"created,omitempty"`
+		ContentDigest string                 `toml:"content-digest,omitempty"`
+		Launch        map[string]interface{} `toml:"launch,omitempty"`
 	} `toml:"metadata"`
 }
 
@@ -620,17 +792,67 @@ func setupLinkEnv(env packfile.EnvMap, links []linkInfo) error {
 
 type metadataMap struct {
 	metadata.Metadata
-	links map[string]metadata.Metadata
+	links  map[string]metadata.Metadata
+	launch metadata.Metadata
+}
+
+func (m metadataMap) Write(value string, keys ...string) error {
+	if len(keys) > 0 {
+		if err := checkReserved(keys[0]); err != nil {
+			return err
+		}
+	}
+	return m.Metadata.Write(value, keys...)
+}
+
+func (m metadataMap) WriteAll(values map[string]interface{}) error {
+	if err := checkReservedAll(values); err != nil {
+		return err
+	}
+	return m.Metadata.WriteAll(values)
+}
+
+func (m metadataMap) Delete(keys ...string) error {
+	if len(keys) > 0 {
+		if err := checkReserved(keys[0]); err != nil {
+			return err
+		}
+	}
+	return m.Metadata.Delete(keys...)
 }
 
 func (m metadataMap) Link(as string) metadata.Metadata {
 	return m.links[as]
 }
 
+// addLink provides read-only metadata for linked layers, but caches may be modified by any linked layer
+func (m metadataMap) addLink(link linkInfo, snapshotDir string) (string, error) {
+	if link.Metadata == nil {
+		return "", nil
+	}
+	if link.cache {
+		m.links[link.MetadataEnv] = link.Metadata
+		if mddir, ok := link.Metadata.(interface{ Dir() string }); ok {
+			return mddir.Dir(), nil
+		}
+		return "", nil
+	}
+	m.links[link.MetadataEnv] = metadata.NewReadOnly(link.Metadata)
+	return metadata.Snapshot(link.Metadata, snapshotDir)
+}
+
+func (m metadataMap) Launch() metadata.Metadata {
+	return m.launch
+}
+
+// Dir returns "" if the metadata is not stored in a directory
 func (m metadataMap) Dir() string {
-	return m.Metadata.(interface{ Dir() string }).Dir()
+	if mddir, ok := m.Metadata.(interface{ Dir() string }); ok {
+		return mddir.Dir()
+	}
+	return ""
 }
 
-func newMetadataMap(md metadata.Metadata) metadataMap {
-	return metadataMap{md, map[string]metadata.Metadata{}}
+func newMetadataMap(md, launch metadata.Metadata) metadataMap {
+	return metadataMap{md, map[string]metadata.Metadata{}, launch}
 }
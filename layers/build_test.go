@@ -0,0 +1,40 @@
+package layers
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/sclevine/packfile/link"
+	"github.com/sclevine/packfile/metadata"
+)
+
+// TestMetadataMapDir checks that metadata without a directory does not report one
+func TestMetadataMapDir(t *testing.T) {
+	if dir := newMetadataMap(metadata.NewMemory(), nil).Dir(); dir != "" {
+		t.Errorf("expected no directory, got: %s", dir)
+	}
+	if dir := newMetadataMap(metadata.NewFS("/md"), nil).Dir(); dir != "/md" {
+		t.Errorf("expected /md, got: %s", dir)
+	}
+}
+
+// TestMergeRequire checks that later requires replace metadata, except launch and build
+func TestMergeRequire(t *testing.T) {
+	md := metadata.NewMemory()
+	for _, req := range []link.Require{
+		{Name: "node", Metadata: map[string]interface{}{"launch": true, "a": "1", "b": "1"}},
+		{Name: "node", Metadata: map[string]interface{}{"build": true, "a": "2"}},
+	} {
+		if err := mergeRequire(md, req); err != nil {
+			t.Fatal(err)
+		}
+	}
+	all, err := md.ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]interface{}{"launch": "true", "build": "true", "a": "2"}
+	if !reflect.DeepEqual(all, expected) {
+		t.Errorf("unexpected metadata: %#v", all)
+	}
+}
@@ -3,12 +3,19 @@ package layers
 import (
 	"crypto/sha256"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode"
 
+	"github.com/dustin/go-humanize"
 	"golang.org/x/xerrors"
 
 	"github.com/sclevine/packfile"
 	"github.com/sclevine/packfile/link"
+	"github.com/sclevine/packfile/metadata"
 	"github.com/sclevine/packfile/sync"
 )
 
@@ -18,19 +25,24 @@ type Cache struct {
 	*sync.Kernel
 	Cache       *packfile.Cache
 	SetupRunner packfile.SetupRunner
+	PruneRunner packfile.SetupRunner
 	AppDir      string
+	PlatformDir string
+	VarEnv      map[string]string
+	tested      map[string]interface{}
 }
 
 func (l *Cache) Info() link.Info {
 	return link.Info{
 		Name:  l.Cache.Name,
 		Share: &l.Share,
+		Cache: true,
 	}
 }
 
 func (l *Cache) Locks(target link.Layer) bool {
 	for _, link := range target.Info().Links {
-		if link.Name == l.Cache.Name {
+		if link.Name == l.Cache.Name && link.Buildpack == "" {
 			return true
 		}
 	}
@@ -53,20 +65,141 @@ func (l *Cache) Test() (exists, matched bool, err error) {
 	}
 	oldDigest := cacheTOML.Metadata.CodeDigest
 	newDigest := l.digest()
+	prev := cacheTOML.Metadata
 	cacheTOML = layerTOML{Cache: true}
 	cacheTOML.Metadata.CodeDigest = newDigest
-	if err := writeTOML(cacheTOML, cacheTOMLPath); err != nil {
+
+	if _, err := os.Stat(l.LayerDir); err == nil {
+		exists = true
+	} else if !xerrors.Is(err, os.ErrNotExist) {
+		return false, false, err
+	}
+	if err := l.restoreMetadata(prev.Saved); err != nil {
 		return false, false, err
 	}
-	if _, err := os.Stat(l.LayerDir); xerrors.Is(err, os.ErrNotExist) {
-		return false, false, nil
-	} else if err != nil {
+	matched = exists && oldDigest == newDigest
+	if matched {
+		reason, err := l.purgeReason(prev.Created)
+		if err != nil {
+			return false, false, err
+		}
+		if reason != "" {
+			fmt.Fprintf(l.Stdout(), "Purging cache '%s': %s.\n", l.Cache.Name, reason)
+			if err := os.RemoveAll(l.LayerDir); err != nil {
+				return false, false, err
+			}
+			exists, matched = false, false
+		}
+	}
+	cacheTOML.Metadata.Created = time.Now().UTC().Format(time.RFC3339)
+	if matched {
+		l.tested = prev.Tested
+		cacheTOML.Metadata.Saved = prev.Saved
+		cacheTOML.Metadata.Tested = prev.Tested
+		// caches created before created was recorded start aging now
+		if prev.Created != "" {
+			cacheTOML.Metadata.Created = prev.Created
+		}
+	}
+	if err := writeTOML(cacheTOML, cacheTOMLPath); err != nil {
 		return false, false, err
 	}
-	if oldDigest != newDigest {
-		return false, false, nil
+	return matched, matched, nil
+}
+
+func (l *Cache) restoreMetadata(saved map[string]interface{}) error {
+	if l.Metadata == nil {
+		return nil
+	}
+	if err := l.Metadata.DeleteAll(); err != nil {
+		return err
+	}
+	return l.Metadata.WriteAll(saved)
+}
+
+// purgeReason returns a non-empty reason if an existing cache should be purged
+func (l *Cache) purgeReason(created string) (string, error) {
+	if l.forcePurge() {
+		return "requested by " + purgeEnv, nil
+	}
+	if l.Cache.MaxAge != "" {
+		maxAge, err := time.ParseDuration(l.Cache.MaxAge)
+		if err != nil {
+			return "", xerrors.Errorf("invalid max-age for cache '%s': %w", l.Cache.Name, err)
+		}
+		if t, err := time.Parse(time.RFC3339, created); err == nil && time.Since(t) > maxAge {
+			return fmt.Sprintf("older than %s", l.Cache.MaxAge), nil
+		}
+	}
+	if l.Cache.MaxSize != "" {
+		maxSize, err := humanize.ParseBytes(l.Cache.MaxSize)
+		if err != nil {
+			return "", xerrors.Errorf("invalid max-size for cache '%s': %w", l.Cache.Name, err)
+		}
+		size, err := dirSize(l.LayerDir)
+		if err != nil {
+			return "", err
+		}
+		if size > maxSize && l.PruneRunner != nil {
+			fmt.Fprintf(l.Stdout(), "Pruning cache '%s' (%s > %s).\n", l.Cache.Name, humanize.Bytes(size), l.Cache.MaxSize)
+			env, err := l.env()
+			if err != nil {
+				return "", err
+			}
+			if err := l.setup(l.PruneRunner, env); err != nil {
+				return "", err
+			}
+			if size, err = dirSize(l.LayerDir); err != nil {
+				return "", err
+			}
+		}
+		if size > maxSize {
+			return fmt.Sprintf("larger than %s (%s)", l.Cache.MaxSize, humanize.Bytes(size)), nil
+		}
+	}
+	return "", nil
+}
+
+const purgeEnv = "PF_PURGE_CACHES"
+
+// forcePurge checks <platform>/env/PF_PURGE_CACHES for the cache name or "*"
+func (l *Cache) forcePurge() bool {
+	names, err := ioutil.ReadFile(filepath.Join(l.PlatformDir, "env", purgeEnv))
+	if err != nil {
+		return false
+	}
+	for _, name := range strings.FieldsFunc(string(names), func(r rune) bool {
+		return r == ',' || unicode.IsSpace(r)
+	}) {
+		if name == l.Cache.Name || name == "*" {
+			return true
+		}
 	}
-	return true, true, nil
+	return false
+}
+
+func pick(md map[string]interface{}, keys []string) map[string]interface{} {
+	out := map[string]interface{}{}
+	for _, key := range keys {
+		if v, ok := metadata.Lookup(md, key); ok {
+			out[key] = v
+		}
+	}
+	return out
+}
+
+func dirSize(dir string) (uint64, error) {
+	var size uint64
+	err := filepath.Walk(dir, func(_ string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.Mode().IsRegular() {
+			size += uint64(fi.Size())
+		}
+		return nil
+	})
+	return size, err
 }
 
 func (l *Cache) Run() error {
@@ -76,25 +209,118 @@ func (l *Cache) Run() error {
 	if err := os.MkdirAll(l.LayerDir, 0777); err != nil {
 		return err
 	}
+	if err := l.resetTOML(); err != nil {
+		return err
+	}
+	if l.Metadata != nil {
+		// invalidate-on values written by linked layers in this build are kept for setup
+		md, err := l.Metadata.ReadAll()
+		if err != nil {
+			return err
+		}
+		if err := l.Metadata.DeleteAll(); err != nil {
+			return err
+		}
+		if err := l.Metadata.WriteAll(pick(md, l.Cache.InvalidateOn)); err != nil {
+			return err
+		}
+	}
 	if l.SetupRunner == nil {
 		return nil
 	}
 	fmt.Fprintf(l.Stdout(), "Setting up cache '%s'.\n", l.Cache.Name)
-	env := packfile.NewEnvMap(os.Environ())
-	env["APP"] = l.AppDir
-	env["CACHE"] = l.LayerDir
-	if err := l.SetupRunner.Setup(l.Streamer, env); err != nil {
+	env, err := l.env()
+	if err != nil {
+		return err
+	}
+	if err := l.setup(l.SetupRunner, env); err != nil {
 		return err
 	}
 	fmt.Fprintf(l.Stdout(), "Setup cache '%s'.\n", l.Cache.Name)
 	return nil
 }
 
+func (l *Cache) setup(runner packfile.SetupRunner, env packfile.EnvMap) error {
+	if mr, ok := runner.(packfile.SetupMetadataRunner); ok {
+		return mr.SetupMetadata(l.Streamer, env, newMetadataMap(l.Metadata, nil))
+	}
+	return runner.Setup(l.Streamer, env)
+}
+
+// resetTOML records that the cache was created now, so that max-age and invalidate-on start over
+func (l *Cache) resetTOML() error {
+	cacheTOMLPath := l.LayerDir + ".toml"
+	cacheTOML, err := readLayerTOML(cacheTOMLPath)
+	if err != nil {
+		return err
+	}
+	cacheTOML.Metadata.Saved = nil
+	cacheTOML.Metadata.Tested = nil
+	cacheTOML.Metadata.Created = time.Now().UTC().Format(time.RFC3339)
+	return writeTOML(cacheTOML, cacheTOMLPath)
+}
+
+// Skip runs after provide.test of linked layers, so invalidate-on compares values written in this build
 func (l *Cache) Skip() error {
+	if reason, err := l.invalidateReason(); err != nil {
+		return err
+	} else if reason != "" {
+		fmt.Fprintf(l.Stdout(), "Purging cache '%s': %s.\n", l.Cache.Name, reason)
+		return l.Run()
+	}
 	fmt.Fprintf(l.Stdout(), "Using existing cache '%s'.\n", l.Cache.Name)
 	return nil
 }
 
+// invalidateReason returns a non-empty reason if invalidate-on keys changed since the cache was set up
+func (l *Cache) invalidateReason() (string, error) {
+	if len(l.Cache.InvalidateOn) == 0 || l.Metadata == nil {
+		return "", nil
+	}
+	md, err := l.Metadata.ReadAll()
+	if err != nil {
+		return "", err
+	}
+	changes := metadata.Diff(l.tested, pick(md, l.Cache.InvalidateOn))
+	if len(changes) == 0 {
+		return "", nil
+	}
+	var out []string
+	for _, c := range changes {
+		out = append(out, c.String())
+	}
+	return "metadata changed (" + strings.Join(out, ", ") + ")", nil
+}
+
+func (l *Cache) env() (packfile.EnvMap, error) {
+	env, err := newEnv(l.PlatformDir, false, l.VarEnv)
+	if err != nil {
+		return nil, err
+	}
+	env["APP"] = l.AppDir
+	env["CACHE"] = l.LayerDir
+	return env, nil
+}
+
+// Save persists cache metadata, including changes made by linked layers, to <cache>.toml
+func (l *Cache) Save() error {
+	if l.Metadata == nil {
+		return nil
+	}
+	cacheTOMLPath := l.LayerDir + ".toml"
+	cacheTOML, err := readLayerTOML(cacheTOMLPath)
+	if err != nil {
+		return err
+	}
+	if cacheTOML.Metadata.Saved, err = l.Metadata.ReadAll(); err != nil {
+		return err
+	}
+	if cacheTOML.Metadata.Tested == nil && len(l.Cache.InvalidateOn) > 0 {
+		cacheTOML.Metadata.Tested = pick(cacheTOML.Metadata.Saved, l.Cache.InvalidateOn)
+	}
+	return writeTOML(cacheTOML, cacheTOMLPath)
+}
+
 func (l *Cache) digest() string {
 	hash := sha256.New()
 	writeField(hash, "cache")
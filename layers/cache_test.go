@@ -0,0 +1,113 @@
+package layers
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sclevine/packfile"
+	"github.com/sclevine/packfile/link"
+	"github.com/sclevine/packfile/metadata"
+)
+
+type testStreamer struct{ out bytes.Buffer }
+
+func (s *testStreamer) Stdout() io.Writer           { return &s.out }
+func (s *testStreamer) Stderr() io.Writer           { return &s.out }
+func (s *testStreamer) Stream(_, _ io.Writer) error { return nil }
+func (s *testStreamer) Close() error                { return nil }
+
+type countSetup struct{ n int }
+
+func (r *countSetup) Setup(packfile.Streamer, packfile.EnvMap) error { r.n++; return nil }
+func (r *countSetup) Version() string                                { return "1" }
+
+// TestCacheInvalidateOn checks that invalidate-on compares values written by linked layers in the same build
+func TestCacheInvalidateOn(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cache-test.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	setup := &countSetup{}
+
+	build := func(version string) {
+		t.Helper()
+		cache := &Cache{
+			Streamer:    &testStreamer{},
+			Share:       link.Share{LayerDir: filepath.Join(dir, "cache"), Metadata: metadata.NewMemory()},
+			Cache:       &packfile.Cache{Name: "cache", InvalidateOn: []string{"version"}},
+			SetupRunner: setup,
+			PlatformDir: dir,
+		}
+		_, matched, err := cache.Test()
+		if err != nil {
+			t.Fatal(err)
+		}
+		// written by the provide.test of a linked layer
+		if err := cache.Metadata.Write(version, "version"); err != nil {
+			t.Fatal(err)
+		}
+		if matched {
+			err = cache.Skip()
+		} else {
+			err = cache.Run()
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v, err := cache.Metadata.Read("version"); err != nil || v != version {
+			t.Errorf("expected version %s after setup, got: %s, %v", version, v, err)
+		}
+		if err := cache.Save(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i, tt := range []struct {
+		version string
+		setups  int
+	}{{"1", 1}, {"1", 1}, {"2", 2}, {"2", 2}, {"1", 3}} {
+		build(tt.version)
+		if setup.n != tt.setups {
+			t.Errorf("build %d: expected %d setups, got %d", i, tt.setups, setup.n)
+		}
+	}
+}
+
+// TestCacheMissingCreated checks that a cache without a creation time starts aging instead of never expiring
+func TestCacheMissingCreated(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cache-test.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	setup := &countSetup{}
+	cache := &Cache{
+		Streamer:    &testStreamer{},
+		Share:       link.Share{LayerDir: filepath.Join(dir, "cache")},
+		Cache:       &packfile.Cache{Name: "cache", MaxAge: "1h"},
+		SetupRunner: setup,
+		PlatformDir: dir,
+	}
+	if err := os.Mkdir(cache.LayerDir, 0777); err != nil {
+		t.Fatal(err)
+	}
+	var cacheTOML layerTOML
+	cacheTOML.Metadata.CodeDigest = cache.digest()
+	if err := writeTOML(cacheTOML, cache.LayerDir+".toml"); err != nil {
+		t.Fatal(err)
+	}
+	if _, matched, err := cache.Test(); err != nil || !matched {
+		t.Fatalf("expected match, got: %t, %v", matched, err)
+	}
+	if cacheTOML, err = readLayerTOML(cache.LayerDir + ".toml"); err != nil {
+		t.Fatal(err)
+	}
+	if cacheTOML.Metadata.Created == "" {
+		t.Error("expected creation time to be recorded")
+	}
+}
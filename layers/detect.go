@@ -1,10 +1,9 @@
 package layers
 
 import (
-	"os"
-
 	"github.com/sclevine/packfile"
 	"github.com/sclevine/packfile/link"
+	"github.com/sclevine/packfile/metadata"
 	"github.com/sclevine/packfile/sync"
 )
 
@@ -14,7 +13,11 @@ type Detect struct {
 	*sync.Kernel
 	Layer         *packfile.Layer
 	RequireRunner packfile.RequireRunner
+	Launch        metadata.Metadata
 	AppDir        string
+	PlatformDir   string
+	Vars          map[string]string
+	VarEnv        map[string]string
 }
 
 func (l *Detect) Info() link.Info {
@@ -44,11 +47,21 @@ func (l *Detect) Run() error {
 	if err := writeLayerMetadata(l.Metadata, l.Layer); err != nil {
 		return err
 	}
+	if err := writeVars(l.Metadata, l.Vars); err != nil {
+		return err
+	}
 	if l.RequireRunner == nil {
 		return nil
 	}
-	env := packfile.NewEnvMap(os.Environ())
-	md := newMetadataMap(l.Metadata)
+	env, err := newEnv(l.PlatformDir, l.Layer.ClearEnv, l.VarEnv)
+	if err != nil {
+		return err
+	}
+	launch := l.Launch
+	if launch == nil {
+		launch = metadata.NewReadOnly(metadata.NewMemory())
+	}
+	md := newMetadataMap(l.Metadata, launch)
 
 	env["APP"] = l.AppDir
 	return l.RequireRunner.Require(l.Streamer, env, md)
@@ -0,0 +1,66 @@
+package layers
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+
+	"github.com/sclevine/packfile"
+	"github.com/sclevine/packfile/metadata"
+)
+
+// externalLayerDir assumes that layerDir is <layers>/<buildpack>/<layer>
+func externalLayerDir(layerDir string, link packfile.Link) string {
+	layersDir := filepath.Dir(filepath.Dir(layerDir))
+	return filepath.Join(layersDir, escapeID(link.Buildpack), link.Name)
+}
+
+func escapeID(id string) string {
+	return strings.Replace(id, "/", "_", -1)
+}
+
+// loadExternalLinks reads metadata for layers of other buildpacks from <layer>.toml.
+// Metadata saved by packfile buildpacks is used when present.
+func (l *Build) loadExternalLinks() error {
+	for _, link := range l.links {
+		if link.Buildpack == "" || link.Metadata != nil {
+			continue
+		}
+		var lt struct {
+			Metadata map[string]interface{} `toml:"metadata"`
+		}
+		if _, err := toml.DecodeFile(link.layerTOML(), &lt); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		values := lt.Metadata
+		if saved, ok := values["saved"].(map[string]interface{}); ok {
+			values = saved
+			if v, ok := lt.Metadata["version"]; ok {
+				values["version"] = v
+			}
+		}
+		md, err := l.externalStore(link.Buildpack, link.Name)
+		if err != nil {
+			return err
+		}
+		if err := md.WriteAll(values); err != nil {
+			return err
+		}
+		link.Metadata = md
+	}
+	return nil
+}
+
+func (l *Build) externalStore(buildpack, name string) (metadata.Metadata, error) {
+	mddir, ok := l.Metadata.(interface{ Dir() string })
+	if !ok {
+		return metadata.NewMemory(), nil
+	}
+	dir := filepath.Join(mddir.Dir(), ".links", escapeID(buildpack), name)
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return nil, err
+	}
+	return metadata.NewFS(dir), nil
+}
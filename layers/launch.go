@@ -0,0 +1,312 @@
+package layers
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/xerrors"
+
+	"github.com/sclevine/packfile"
+	"github.com/sclevine/packfile/link"
+	"github.com/sclevine/packfile/metadata"
+	"github.com/sclevine/packfile/sync"
+)
+
+type Launch struct {
+	Processes []packfile.Process
+	Slices    []packfile.Slice
+}
+
+// NewLaunch interpolates processes and slices with every layer that was successfully built or restored available as a link.
+// Processes contributed by those layers are appended, replacing any earlier processes of the same type.
+func NewLaunch(pf *packfile.Packfile, layers []link.Layer, appDir, platformDir string, vars map[string]string) (Launch, error) {
+	links := map[string]linkData{}
+	for _, layer := range layers {
+		if sync.NodeError(layer) != nil {
+			continue
+		}
+		info := layer.Info()
+		var err error
+		if links[info.Name], err = readLinkData(info.Share); err != nil {
+			return Launch{}, err
+		}
+	}
+	data := templateData{
+		App:      appDir,
+		Platform: platformDir,
+		Vars:     vars,
+		Links:    links,
+	}.toMap()
+	procs, err := interpolateProcesses(pf.Processes, data)
+	if err != nil {
+		return Launch{}, err
+	}
+	slices, err := expandSlices(pf.Slices, appDir, data)
+	if err != nil {
+		return Launch{}, err
+	}
+	out := Launch{
+		Processes: procs,
+		Slices:    slices,
+	}
+	for _, layer := range layers {
+		build, ok := layer.(*Build)
+		if !ok || sync.NodeError(layer) != nil {
+			continue
+		}
+		if err := build.addLaunch(&out); err != nil {
+			return Launch{}, xerrors.Errorf("invalid launch config for layer '%s': %w", build.Layer.Name, err)
+		}
+	}
+	return out, nil
+}
+
+// requireLaunchKey holds launch contributions from require in the metadata of the layer's plan entry
+const requireLaunchKey = ".launch"
+
+// AddRequireLaunch adds launch contributions from require to the plan entries of the layers that made them.
+func AddRequireLaunch(requires []link.Require, layers []link.Layer) error {
+	for _, layer := range layers {
+		detect, ok := layer.(*Detect)
+		if !ok || detect.Launch == nil || sync.NodeError(layer) != nil {
+			continue
+		}
+		launch, err := detect.Launch.ReadAll()
+		if err != nil {
+			return err
+		}
+		if len(launch) == 0 {
+			continue
+		}
+		if detect.Layer.Provide == nil && detect.Layer.Build == nil {
+			return xerrors.Errorf("layer '%s' contributes to launch from require but does not provide", detect.Layer.Name)
+		}
+		for i := range requires {
+			if requires[i].Name != detect.Layer.Name {
+				continue
+			}
+			if requires[i].Metadata == nil {
+				requires[i].Metadata = map[string]interface{}{}
+			}
+			requires[i].Metadata[requireLaunchKey] = launch
+		}
+	}
+	return nil
+}
+
+// splitRequireLaunch removes launch contributions from require from plan entries, later entries win
+func splitRequireLaunch(reqs []link.Require) ([]link.Require, metadata.Metadata, error) {
+	launch := metadata.NewMemory()
+	var out []link.Require
+	for _, req := range reqs {
+		md := map[string]interface{}{}
+		for k, v := range req.Metadata {
+			if k != requireLaunchKey {
+				md[k] = v
+				continue
+			}
+			m, ok := v.(map[string]interface{})
+			if !ok {
+				return nil, nil, xerrors.Errorf("invalid launch metadata for layer '%s': %w", req.Name, metadata.ErrNotKey)
+			}
+			if err := launch.WriteAll(m); err != nil {
+				return nil, nil, xerrors.Errorf("invalid launch metadata for layer '%s': %w", req.Name, err)
+			}
+		}
+		req.Metadata = md
+		out = append(out, req)
+	}
+	return out, launch, nil
+}
+
+func (l *Build) addLaunch(out *Launch) error {
+	p := l.provide()
+	if len(p.Processes) > 0 || len(p.Slices) > 0 {
+		data, err := l.templateData()
+		if err != nil {
+			return err
+		}
+		procs, err := interpolateProcesses(p.Processes, data)
+		if err != nil {
+			return err
+		}
+		out.Processes = mergeProcesses(out.Processes, procs)
+		slices, err := expandSlices(p.Slices, l.AppDir, data)
+		if err != nil {
+			return err
+		}
+		out.Slices = append(out.Slices, slices...)
+	}
+	if len(p.AutoSlice) > 0 {
+		md, err := l.Metadata.ReadAll()
+		if err != nil {
+			return err
+		}
+		slices, err := autoSlices(md, p.AutoSlice, l.AppDir)
+		if err != nil {
+			return err
+		}
+		out.Slices = append(out.Slices, slices...)
+	}
+	for _, md := range []metadata.Metadata{l.requireLaunch, l.Launch} {
+		if md == nil {
+			continue
+		}
+		if err := readLaunch(out, md); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readLaunch(out *Launch, md metadata.Metadata) error {
+	launch, err := md.ReadAll()
+	if err != nil {
+		return err
+	}
+	procs, err := readProcesses(launch)
+	if err != nil {
+		return err
+	}
+	out.Processes = mergeProcesses(out.Processes, procs)
+	slices, err := readSlices(launch)
+	if err != nil {
+		return err
+	}
+	out.Slices = append(out.Slices, slices...)
+	return nil
+}
+
+// launch metadata layout:
+// processes/<type>/{command,args,direct}, slices/<name>
+// args and slice paths are lists or newline-separated strings
+func readProcesses(launch map[string]interface{}) ([]packfile.Process, error) {
+	procs, err := launchMap(launch, "processes")
+	if err != nil {
+		return nil, err
+	}
+	var out []packfile.Process
+	for _, t := range sortedKeys(procs) {
+		proc, ok := procs[t].(map[string]interface{})
+		if !ok {
+			return nil, xerrors.Errorf("process '%s': %w", t, metadata.ErrNotKey)
+		}
+		command, err := launchString(proc["command"])
+		if err != nil {
+			return nil, xerrors.Errorf("process '%s' command: %w", t, err)
+		}
+		args, err := launchList(proc["args"])
+		if err != nil {
+			return nil, xerrors.Errorf("process '%s' args: %w", t, err)
+		}
+		direct, err := launchString(proc["direct"])
+		if err != nil {
+			return nil, xerrors.Errorf("process '%s' direct: %w", t, err)
+		}
+		out = append(out, packfile.Process{
+			Type:    t,
+			Command: command,
+			Args:    args,
+			Direct:  direct == "true",
+		})
+	}
+	return out, nil
+}
+
+func readSlices(launch map[string]interface{}) ([]packfile.Slice, error) {
+	slices, err := launchMap(launch, "slices")
+	if err != nil {
+		return nil, err
+	}
+	var out []packfile.Slice
+	for _, k := range sortedKeys(slices) {
+		paths, err := launchList(slices[k])
+		if err != nil {
+			return nil, xerrors.Errorf("slice '%s': %w", k, err)
+		}
+		out = append(out, packfile.Slice{Paths: paths})
+	}
+	return out, nil
+}
+
+func launchMap(launch map[string]interface{}, key string) (map[string]interface{}, error) {
+	switch v := launch[key].(type) {
+	case nil:
+		return nil, nil
+	case map[string]interface{}:
+		return v, nil
+	default:
+		return nil, xerrors.Errorf("%s: %w", key, metadata.ErrNotKey)
+	}
+}
+
+func launchString(v interface{}) (string, error) {
+	switch v := v.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return strings.TrimSuffix(v, "\n"), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64), nil
+	default:
+		return "", metadata.ErrNotValue
+	}
+}
+
+func launchList(v interface{}) ([]string, error) {
+	var out []string
+	switch v := v.(type) {
+	case nil:
+	case []string:
+		out = append(out, v...)
+	case []interface{}:
+		for _, e := range v {
+			s, err := launchString(e)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, s)
+		}
+	default:
+		s, err := launchString(v)
+		if err != nil {
+			return nil, err
+		}
+		for _, line := range strings.Split(s, "\n") {
+			if line != "" {
+				out = append(out, line)
+			}
+		}
+	}
+	return out, nil
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	var keys []string
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func mergeProcesses(procs, next []packfile.Process) []packfile.Process {
+	for _, n := range next {
+		replaced := false
+		for i := range procs {
+			if procs[i].Type == n.Type {
+				procs[i] = n
+				replaced = true
+			}
+		}
+		if !replaced {
+			procs = append(procs, n)
+		}
+	}
+	return procs
+}
@@ -0,0 +1,161 @@
+package layers
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/BurntSushi/toml"
+	"golang.org/x/xerrors"
+
+	"github.com/sclevine/packfile"
+	"github.com/sclevine/packfile/link"
+	"github.com/sclevine/packfile/metadata"
+	"github.com/sclevine/packfile/sync"
+)
+
+var _ packfile.LaunchMetadata = metadataMap{}
+
+// TestReadLaunchTyped checks that typed launch values are read as well as their text forms
+func TestReadLaunchTyped(t *testing.T) {
+	launch := map[string]interface{}{
+		"processes": map[string]interface{}{
+			"list": map[string]interface{}{
+				"command": "node",
+				"args":    []string{"server.js", "--port"},
+				"direct":  true,
+			},
+			"mixed": map[string]interface{}{
+				"command": "sleep",
+				"args":    []interface{}{"-n", int64(5), 1.5, false},
+				"direct":  "true",
+			},
+			"text": map[string]interface{}{
+				"command": "npm\n",
+				"args":    "start\n--\n",
+				"direct":  false,
+			},
+			"number": map[string]interface{}{
+				"command": int64(7),
+			},
+		},
+		"slices": map[string]interface{}{
+			"a": []string{"vendor/*", "node_modules"},
+			"b": []interface{}{"public"},
+			"c": "src\nlib\n",
+		},
+	}
+	procs, err := readProcesses(launch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedProcs := []packfile.Process{
+		{Type: "list", Command: "node", Args: []string{"server.js", "--port"}, Direct: true},
+		{Type: "mixed", Command: "sleep", Args: []string{"-n", "5", "1.5", "false"}, Direct: true},
+		{Type: "number", Command: "7"},
+		{Type: "text", Command: "npm", Args: []string{"start", "--"}},
+	}
+	if !reflect.DeepEqual(procs, expectedProcs) {
+		t.Errorf("unexpected processes: %#v", procs)
+	}
+	slices, err := readSlices(launch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedSlices := []packfile.Slice{
+		{Paths: []string{"vendor/*", "node_modules"}},
+		{Paths: []string{"public"}},
+		{Paths: []string{"src", "lib"}},
+	}
+	if !reflect.DeepEqual(slices, expectedSlices) {
+		t.Errorf("unexpected slices: %#v", slices)
+	}
+}
+
+// TestReadLaunchUnsupported checks that values that cannot be used in launch.toml fail instead of being dropped
+func TestReadLaunchUnsupported(t *testing.T) {
+	for _, launch := range []map[string]interface{}{
+		{"processes": map[string]interface{}{"web": map[string]interface{}{"command": map[string]interface{}{"a": "b"}}}},
+		{"processes": map[string]interface{}{"web": map[string]interface{}{"args": []interface{}{map[string]interface{}{}}}}},
+		{"processes": map[string]interface{}{"web": map[string]interface{}{"direct": []string{"true"}}}},
+		{"processes": map[string]interface{}{"web": "node"}},
+		{"slices": map[string]interface{}{"a": map[string]interface{}{"b": "c"}}},
+	} {
+		_, perr := readProcesses(launch)
+		_, serr := readSlices(launch)
+		if perr == nil && serr == nil {
+			t.Errorf("expected error for %#v", launch)
+		}
+		for _, err := range []error{perr, serr} {
+			if err != nil && !xerrors.Is(err, metadata.ErrNotValue) && !xerrors.Is(err, metadata.ErrNotKey) {
+				t.Errorf("unexpected error for %#v: %v", launch, err)
+			}
+		}
+	}
+}
+
+// TestRequireLaunch checks that launch contributions from require reach the build through a plan entry
+func TestRequireLaunch(t *testing.T) {
+	detect := &Detect{
+		Kernel: sync.NewKernel("web", sync.NewLock(), false),
+		Layer:  &packfile.Layer{Name: "web", Provide: &packfile.Provide{}},
+		Launch: metadata.NewMemory(),
+	}
+	if err := detect.Launch.WriteAll(map[string]interface{}{
+		"processes": map[string]interface{}{
+			"web": map[string]interface{}{"command": "node", "args": []string{"a", "b"}, "direct": true},
+		},
+		"slices": map[string]interface{}{"modules": []string{"node_modules"}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	requires := []link.Require{{Name: "web", Metadata: map[string]interface{}{"version": "1.0"}}}
+	if err := AddRequireLaunch(requires, []link.Layer{detect}); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := &bytes.Buffer{}
+	if err := toml.NewEncoder(buf).Encode(struct {
+		Entries []link.Require `toml:"entries"`
+	}{requires}); err != nil {
+		t.Fatal(err)
+	}
+	var plan struct {
+		Entries []link.Require `toml:"entries"`
+	}
+	if _, err := toml.Decode(buf.String(), &plan); err != nil {
+		t.Fatal(err)
+	}
+
+	reqs, launch, err := splitRequireLaunch(plan.Entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reqs) != 1 || !reflect.DeepEqual(reqs[0].Metadata, map[string]interface{}{"version": "1.0"}) {
+		t.Errorf("unexpected requires: %#v", reqs)
+	}
+	build := &Build{
+		Layer:         &packfile.Layer{Name: "web", Provide: &packfile.Provide{}},
+		Launch:        metadata.NewMemory(),
+		requireLaunch: launch,
+	}
+	if err := build.Launch.Write("npm", "processes", "web", "command"); err != nil {
+		t.Fatal(err)
+	}
+	var out Launch
+	if err := build.addLaunch(&out); err != nil {
+		t.Fatal(err)
+	}
+	expected := Launch{
+		Processes: []packfile.Process{{Type: "web", Command: "npm"}},
+		Slices:    []packfile.Slice{{Paths: []string{"node_modules"}}},
+	}
+	if !reflect.DeepEqual(out, expected) {
+		t.Errorf("unexpected launch: %#v", out)
+	}
+
+	detect.Layer.Provide = nil
+	if err := AddRequireLaunch(requires, []link.Layer{detect}); err == nil {
+		t.Error("expected error for layer without provide")
+	}
+}
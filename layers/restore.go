@@ -0,0 +1,140 @@
+package layers
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+const restoreDigestFile = ".content-digest"
+
+// saveRestore copies layer contents into the local restore cache, so that
+// exported layers that are not stored can be restored in later builds.
+func (l *Build) saveRestore(digest string) error {
+	if err := os.RemoveAll(l.RestoreDir); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(l.RestoreDir), 0777); err != nil {
+		return err
+	}
+	tmpDir := l.RestoreDir + ".tmp"
+	if err := os.RemoveAll(tmpDir); err != nil {
+		return err
+	}
+	if err := copyDir(l.LayerDir, filepath.Join(tmpDir, "layer")); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(tmpDir, restoreDigestFile), []byte(digest), 0666); err != nil {
+		return err
+	}
+	return os.Rename(tmpDir, l.RestoreDir)
+}
+
+// restore copies layer contents from the local restore cache if they match the digest recorded by the last build
+func (l *Build) restore(digest string) (bool, error) {
+	if digest == "" {
+		return false, nil
+	}
+	saved, err := ioutil.ReadFile(filepath.Join(l.RestoreDir, restoreDigestFile))
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	if string(saved) != digest {
+		return false, nil
+	}
+	src := filepath.Join(l.RestoreDir, "layer")
+	if actual, err := contentDigest(src); err != nil || actual != digest {
+		return false, err
+	}
+	if err := copyDir(src, l.LayerDir); err != nil {
+		os.RemoveAll(l.LayerDir)
+		return false, err
+	}
+	return true, nil
+}
+
+// contentDigest hashes the paths, modes, link targets, and file contents in dir
+func contentDigest(dir string) (string, error) {
+	hash := sha256.New()
+	err := filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() {
+			writeField(hash, rel)
+		} else {
+			writeField(hash, rel, fi.Mode())
+		}
+		switch {
+		case fi.Mode()&os.ModeSymlink != 0:
+			target, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			writeField(hash, target)
+		case fi.Mode().IsRegular():
+			f, err := os.Open(path)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			if _, err := io.Copy(hash, f); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return fmt.Sprintf("%x", hash.Sum(nil)), err
+}
+
+func copyDir(src, dst string) error {
+	return filepath.Walk(src, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		switch {
+		case fi.IsDir():
+			return os.MkdirAll(target, fi.Mode().Perm()|0700)
+		case fi.Mode()&os.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		case fi.Mode().IsRegular():
+			return copyFile(path, target, fi.Mode().Perm())
+		}
+		return nil
+	})
+}
+
+func copyFile(src, dst string, mode os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	if _, err := io.Copy(out, in); err != nil {
+		return err
+	}
+	return out.Chmod(mode)
+}
@@ -0,0 +1,115 @@
+package layers
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/xerrors"
+
+	"github.com/sclevine/packfile"
+	"github.com/sclevine/packfile/exec"
+	"github.com/sclevine/packfile/metadata"
+)
+
+const defaultBackoff = time.Second
+
+func (l *Build) retries() bool {
+	return l.Layer.Retry != nil && l.Layer.Retry.Attempts > 1
+}
+
+// retry calls reset before each attempt after the first
+func (l *Build) retry(fn, reset func() error) error {
+	r := l.Layer.Retry
+	if !l.retries() {
+		return fn()
+	}
+	backoff := defaultBackoff
+	if r.Backoff != "" {
+		var err error
+		if backoff, err = time.ParseDuration(r.Backoff); err != nil {
+			return xerrors.Errorf("invalid retry backoff for layer '%s': %w", l.Layer.Name, err)
+		}
+	}
+	for i := 1; ; i++ {
+		if i > 1 {
+			if err := reset(); err != nil {
+				return err
+			}
+		}
+		err := fn()
+		if err == nil || i >= r.Attempts || !retryable(r, err) {
+			return err
+		}
+		fmt.Fprintf(l.Stdout(), "Attempt %d of %d for layer '%s' failed: %s\n", i, r.Attempts, l.Layer.Name, err)
+		fmt.Fprintf(l.Stdout(), "Retrying layer '%s' in %s...\n", l.Layer.Name, backoff)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+func retryable(r *packfile.Retry, err error) bool {
+	if len(r.Codes) == 0 {
+		return true
+	}
+	var code exec.CodeError
+	if !xerrors.As(err, &code) {
+		return false
+	}
+	for _, c := range r.Codes {
+		if c == int(code) {
+			return true
+		}
+	}
+	return false
+}
+
+// attemptReset snapshots metadata, launch data, and previous layer contents so that
+// failed attempts do not affect later attempts
+func (l *Build) attemptReset(tmpDir string) (func() error, error) {
+	if !l.retries() {
+		return nil, nil
+	}
+	md, err := l.Metadata.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	var launch map[string]interface{}
+	if l.Launch != nil {
+		if launch, err = l.Launch.ReadAll(); err != nil {
+			return nil, err
+		}
+	}
+	var backup string
+	if l.previous != "" {
+		backup = filepath.Join(tmpDir, "backup")
+		if err := copyDir(l.previous, backup); err != nil {
+			return nil, err
+		}
+	}
+	return func() error {
+		if err := restoreStore(l.Metadata, md); err != nil {
+			return err
+		}
+		if l.Launch != nil {
+			if err := restoreStore(l.Launch, launch); err != nil {
+				return err
+			}
+		}
+		if backup == "" {
+			return nil
+		}
+		if err := os.RemoveAll(l.previous); err != nil {
+			return err
+		}
+		return copyDir(backup, l.previous)
+	}, nil
+}
+
+func restoreStore(store metadata.Metadata, values map[string]interface{}) error {
+	if err := store.DeleteAll(); err != nil {
+		return err
+	}
+	return store.WriteAll(values)
+}
@@ -0,0 +1,135 @@
+package layers
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/xerrors"
+
+	"github.com/sclevine/packfile"
+	"github.com/sclevine/packfile/metadata"
+)
+
+// expandSlices interpolates slice paths and resolves slices with exclusions into explicit lists of files.
+// Slices without exclusions are passed through, so that globs are resolved by the lifecycle.
+func expandSlices(slices []packfile.Slice, appDir string, data map[string]interface{}) ([]packfile.Slice, error) {
+	var out []packfile.Slice
+	for _, slice := range slices {
+		var paths []string
+		for _, path := range slice.Paths {
+			path, err := interpolate(path, data)
+			if err != nil {
+				return nil, err
+			}
+			if path != "" {
+				paths = append(paths, path)
+			}
+		}
+		if len(slice.Exclude) > 0 {
+			var err error
+			if paths, err = globExclude(appDir, paths, slice.Exclude); err != nil {
+				return nil, err
+			}
+		}
+		if len(paths) > 0 {
+			out = append(out, packfile.Slice{Paths: paths})
+		}
+	}
+	return out, nil
+}
+
+func globExclude(dir string, globs, exclude []string) ([]string, error) {
+	var out []string
+	seen := map[string]bool{}
+	for _, glob := range globs {
+		matches, err := filepath.Glob(filepath.Join(dir, glob))
+		if err != nil {
+			return nil, err
+		}
+		for _, match := range matches {
+			if err := filepath.Walk(match, func(path string, info os.FileInfo, err error) error {
+				if err != nil {
+					return err
+				}
+				rel, err := filepath.Rel(dir, path)
+				if err != nil {
+					return err
+				}
+				if excluded, err := matchAny(exclude, rel); err != nil {
+					return err
+				} else if excluded {
+					if info.IsDir() {
+						return filepath.SkipDir
+					}
+					return nil
+				}
+				if !info.IsDir() && !seen[rel] {
+					seen[rel] = true
+					out = append(out, rel)
+				}
+				return nil
+			}); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return out, nil
+}
+
+// matchAny matches patterns against the path and each of its parent directories
+func matchAny(patterns []string, path string) (bool, error) {
+	for p := path; p != "." && p != string(filepath.Separator); p = filepath.Dir(p) {
+		for _, pattern := range patterns {
+			if ok, err := filepath.Match(strings.TrimSuffix(pattern, "/"), p); err != nil || ok {
+				return ok, err
+			}
+		}
+	}
+	return false, nil
+}
+
+// autoSlices returns a slice for each listed metadata key whose value names existing paths inside the app dir,
+// such as a vendor directory recorded by the layer. Paths are relative to the app dir or absolute.
+// Keys that are missing or name paths that do not exist are skipped.
+func autoSlices(md map[string]interface{}, keys []string, appDir string) ([]packfile.Slice, error) {
+	var out []packfile.Slice
+	for _, key := range keys {
+		v, ok := metadata.Lookup(md, key)
+		if !ok {
+			continue
+		}
+		var values []string
+		switch v := v.(type) {
+		case string:
+			values = []string{v}
+		case []string:
+			values = v
+		default:
+			return nil, xerrors.Errorf("invalid auto-slice key '%s': %w", key, metadata.ErrNotValue)
+		}
+		var paths []string
+		for _, path := range values {
+			if path == "" {
+				continue
+			}
+			if !filepath.IsAbs(path) {
+				path = filepath.Join(appDir, path)
+			}
+			rel, err := filepath.Rel(appDir, filepath.Clean(path))
+			if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+				return nil, xerrors.Errorf("invalid auto-slice key '%s': path '%s' is not inside the app dir", key, path)
+			}
+			if _, err := os.Lstat(filepath.Join(appDir, rel)); os.IsNotExist(err) {
+				continue
+			} else if err != nil {
+				return nil, err
+			}
+			paths = append(paths, rel)
+		}
+		if len(paths) > 0 {
+			out = append(out, packfile.Slice{Paths: paths})
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,60 @@
+package layers
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/sclevine/packfile"
+)
+
+// TestAutoSlices checks that only listed keys are sliced, and that values that are not app paths are not
+func TestAutoSlices(t *testing.T) {
+	appDir, err := ioutil.TempDir("", "slices-test.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(appDir)
+	for _, dir := range []string{"vendor", "lib", "12.16.1", "true"} {
+		if err := os.Mkdir(filepath.Join(appDir, dir), 0777); err != nil {
+			t.Fatal(err)
+		}
+	}
+	md := map[string]interface{}{
+		"vendor_path": "vendor",
+		"version":     "12.16.1",
+		"enabled":     "true",
+		"libs":        []string{"lib", filepath.Join(appDir, "vendor"), "missing"},
+		"deps":        map[string]interface{}{"path": "vendor/"},
+		"missing":     "missing",
+		"empty":       "",
+		"count":       int64(3),
+		"table":       map[string]interface{}{"a": "lib"},
+		"outside":     "../",
+		"app":         ".",
+	}
+
+	slices, err := autoSlices(md, []string{"vendor_path", "libs", "deps.path", "missing", "empty", "unset"}, appDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []packfile.Slice{
+		{Paths: []string{"vendor"}},
+		{Paths: []string{"lib", "vendor"}},
+		{Paths: []string{"vendor"}},
+	}
+	if !reflect.DeepEqual(slices, expected) {
+		t.Errorf("unexpected slices: %#v", slices)
+	}
+
+	if slices, err := autoSlices(md, nil, appDir); err != nil || len(slices) != 0 {
+		t.Errorf("expected no slices without keys, got: %#v, %v", slices, err)
+	}
+	for _, key := range []string{"count", "table", "outside", "app"} {
+		if _, err := autoSlices(md, []string{key}, appDir); err == nil {
+			t.Errorf("expected error for key '%s'", key)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package layers
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"text/template"
+
+	"github.com/sclevine/packfile"
+	"github.com/sclevine/packfile/link"
+)
+
+var templateFuncs = template.FuncMap{
+	"semverMajor": func(v string) string { return semverPart(v, 0) },
+	"semverMinor": func(v string) string { return semverPart(v, 1) },
+	"semverPatch": func(v string) string { return semverPart(v, 2) },
+	"replace": func(old, new, s string) string {
+		return strings.Replace(s, old, new, -1)
+	},
+	"default": func(def string, v interface{}) interface{} {
+		if v == nil || v == "" {
+			return def
+		}
+		return v
+	},
+}
+
+func semverPart(v string, i int) string {
+	v = strings.TrimPrefix(v, "v")
+	v = strings.SplitN(v, "-", 2)[0]
+	v = strings.SplitN(v, "+", 2)[0]
+	parts := strings.Split(v, ".")
+	if i >= len(parts) {
+		return ""
+	}
+	return parts[i]
+}
+
+func interpolate(text string, vars interface{}) (string, error) {
+	tmpl, err := template.New("vars").Funcs(templateFuncs).Parse(text)
+	if err != nil {
+		return "", err
+	}
+	out := &bytes.Buffer{}
+	if err := tmpl.Execute(out, vars); err != nil {
+		return "", err
+	}
+	return out.String(), nil
+}
+
+type linkData struct {
+	Path     string
+	Version  string
+	Metadata map[string]interface{}
+}
+
+func readLinkData(share *link.Share) (linkData, error) {
+	lt, err := readLayerTOML(share.LayerDir + ".toml")
+	if err != nil {
+		return linkData{}, err
+	}
+	out := linkData{
+		Path:    share.LayerDir,
+		Version: lt.Metadata.Version,
+	}
+	if share.Metadata != nil {
+		if out.Metadata, err = share.Metadata.ReadAll(); err != nil {
+			return linkData{}, err
+		}
+	}
+	return out, nil
+}
+
+type templateData struct {
+	App      string
+	Layer    string
+	Platform string
+	Vars     map[string]string
+	Metadata map[string]interface{}
+	Links    map[string]linkData
+}
+
+// top-level metadata keys are preserved for compatibility with {{.version}}-style templates
+func (d templateData) toMap() map[string]interface{} {
+	out := map[string]interface{}{}
+	for k, v := range d.Metadata {
+		out[k] = v
+	}
+	out["App"] = d.App
+	out["Layer"] = d.Layer
+	out["Platform"] = d.Platform
+	out["Stack"] = os.Getenv("CNB_STACK_ID")
+	out["Vars"] = d.Vars
+	out["Metadata"] = d.Metadata
+	out["Links"] = d.Links
+	return out
+}
+
+func (l *Build) templateData() (map[string]interface{}, error) {
+	md, err := l.Metadata.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	links := map[string]linkData{}
+	for _, link := range l.links {
+		if links[linkKey(link.Link)], err = readLinkData(link.Share); err != nil {
+			return nil, err
+		}
+	}
+	return templateData{
+		App:      l.AppDir,
+		Layer:    l.LayerDir,
+		Platform: l.PlatformDir,
+		Vars:     l.Vars,
+		Metadata: md,
+		Links:    links,
+	}.toMap(), nil
+}
+
+// linkKey keys links to layers of other buildpacks by <buildpack id>/<layer name>, so that they never collide
+// with each other or with layers of this buildpack (layer names cannot contain '/')
+func linkKey(link packfile.Link) string {
+	if link.Buildpack != "" {
+		return link.Buildpack + "/" + link.Name
+	}
+	return link.Name
+}
+
+func interpolateProcesses(procs []packfile.Process, data map[string]interface{}) ([]packfile.Process, error) {
+	var out []packfile.Process
+	for _, proc := range procs {
+		var err error
+		if proc.Command, err = interpolate(proc.Command, data); err != nil {
+			return nil, err
+		}
+		var args []string
+		for _, arg := range proc.Args {
+			arg, err := interpolate(arg, data)
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, arg)
+		}
+		proc.Args = args
+		out = append(out, proc)
+	}
+	return out, nil
+}
@@ -0,0 +1,71 @@
+package layers
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sclevine/packfile"
+	"github.com/sclevine/packfile/link"
+	"github.com/sclevine/packfile/metadata"
+)
+
+// TestTemplateLinks checks that links to layers with the same name in different buildpacks do not collide
+func TestTemplateLinks(t *testing.T) {
+	l := &Build{
+		Share: link.Share{Metadata: metadata.NewMemory()},
+		links: []linkInfo{
+			{Link: packfile.Link{Name: "node"}, Share: &link.Share{LayerDir: "/layers/pf/node"}},
+			{Link: packfile.Link{Name: "node", Buildpack: "org/node-engine"}, Share: &link.Share{LayerDir: "/layers/org_node-engine/node"}},
+			{Link: packfile.Link{Name: "node", Buildpack: "other"}, Share: &link.Share{LayerDir: "/layers/other/node"}},
+		},
+	}
+	data, err := l.templateData()
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := interpolate(`{{.Links.node.Path}} {{(index .Links "org/node-engine/node").Path}} {{(index .Links "other/node").Path}}`, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "/layers/pf/node /layers/org_node-engine/node /layers/other/node"; out != expected {
+		t.Errorf("expected '%s', got: '%s'", expected, out)
+	}
+}
+
+// TestSetupProfile checks that inline profiles are templated, while profile files are copied as-is
+func TestSetupProfile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "profile-test.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	script := filepath.Join(dir, "script.sh")
+	if err := ioutil.WriteFile(script, []byte(`echo "{{not a template"`), 0666); err != nil {
+		t.Fatal(err)
+	}
+	layerDir := filepath.Join(dir, "layer")
+	if err := os.Mkdir(layerDir, 0777); err != nil {
+		t.Fatal(err)
+	}
+	l := &Build{
+		Share: link.Share{LayerDir: layerDir, Metadata: metadata.NewMemory()},
+		Layer: &packfile.Layer{Provide: &packfile.Provide{Profile: []packfile.File{
+			{Inline: "export DIR={{.Layer}}"},
+			{Path: script},
+		}}},
+	}
+	if err := l.setupProfile(); err != nil {
+		t.Fatal(err)
+	}
+	for name, expected := range map[string]string{
+		"0.sh": "export DIR=" + layerDir,
+		"1.sh": `echo "{{not a template"`,
+	} {
+		out, err := ioutil.ReadFile(filepath.Join(layerDir, "profile.d", name))
+		if err != nil || string(out) != expected {
+			t.Errorf("expected '%s' in %s, got: '%s', %v", expected, name, out, err)
+		}
+	}
+}
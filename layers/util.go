@@ -4,6 +4,8 @@ import (
 	"os"
 
 	"github.com/BurntSushi/toml"
+	lcenv "github.com/buildpacks/lifecycle/env"
+	"golang.org/x/xerrors"
 
 	"github.com/sclevine/packfile"
 	"github.com/sclevine/packfile/link"
@@ -13,13 +15,35 @@ import (
 type linkInfo struct {
 	packfile.Link
 	*link.Share
+	cache bool
 }
 
 func (l linkInfo) layerTOML() string {
 	return l.LayerDir + ".toml"
 }
 
+// newEnv sets config var env values after loading the platform env, so that they are never cleared
+func newEnv(platformDir string, clearEnv bool, varEnv map[string]string) (packfile.EnvMap, error) {
+	env := packfile.NewEnvMap(os.Environ())
+	if !clearEnv {
+		lcEnv := lcenv.Env{RootDirMap: lcenv.POSIXBuildEnv, Vars: env}
+		environ, err := lcEnv.WithPlatform(platformDir)
+		if err != nil {
+			return nil, err
+		}
+		env = packfile.NewEnvMap(environ)
+	}
+	for k, v := range varEnv {
+		env[k] = v
+	}
+	env["PLATFORM"] = platformDir
+	return env, nil
+}
+
 func writeLayerMetadata(md metadata.Metadata, layer *packfile.Layer) error {
+	if err := checkReservedAll(layer.Metadata); err != nil {
+		return err
+	}
 	if err := md.WriteAll(layer.Metadata); err != nil {
 		return err
 	}
@@ -43,4 +67,43 @@ func writeTOML(v interface{}, path string) error {
 	}
 	defer f.Close()
 	return toml.NewEncoder(f).Encode(v)
-}
\ No newline at end of file
+}
+
+// writeVars stores config vars under the hidden .vars key, so that they are not saved or added to the build plan
+func writeVars(md metadata.Metadata, vars map[string]string) error {
+	if err := md.Delete(".vars"); err != nil {
+		return err
+	}
+	if len(vars) == 0 {
+		return nil
+	}
+	values := map[string]interface{}{}
+	for k, v := range vars {
+		values[k] = v
+	}
+	return md.WriteAll(map[string]interface{}{".vars": values})
+}
+
+// reservedKeys are hidden keys written by packfile, which may be read but not modified by runners, layers, or requires
+var reservedKeys = map[string]struct{}{
+	".launch":   {},
+	".links":    {},
+	".requires": {},
+	".vars":     {},
+}
+
+func checkReserved(key string) error {
+	if _, ok := reservedKeys[key]; ok {
+		return xerrors.Errorf("key '%s' is reserved: %w", key, metadata.ErrInvalidKey)
+	}
+	return nil
+}
+
+func checkReservedAll(values map[string]interface{}) error {
+	for k := range values {
+		if err := checkReserved(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+package layers
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+	"golang.org/x/xerrors"
+
+	"github.com/sclevine/packfile/link"
+)
+
+// mergeVersions returns the intersection of all requested versions.
+// Exact versions must satisfy every other requested constraint, while ranges are combined.
+// If any version is not valid semver, the last requested version is returned.
+func mergeVersions(name string, reqs []link.Require) (string, error) {
+	var versions []string
+	var last string
+	for _, req := range reqs {
+		if req.Version != "" {
+			versions = appendUnique(versions, req.Version)
+			last = req.Version
+		}
+		if v, ok := req.Metadata["version"]; ok && v != nil {
+			versions = appendUnique(versions, fmt.Sprint(v))
+			last = fmt.Sprint(v)
+		}
+	}
+	var (
+		exact       *semver.Version
+		exactStr    string
+		constraints []string
+	)
+	for _, v := range versions {
+		if v == "" || v == "*" {
+			continue
+		}
+		if sv, err := semver.StrictNewVersion(strings.TrimPrefix(v, "v")); err == nil {
+			if exact != nil && !exact.Equal(sv) {
+				return "", versionConflict(name, versions)
+			}
+			exact, exactStr = sv, v
+			continue
+		}
+		if _, err := semver.NewConstraint(v); err != nil {
+			return last, nil
+		}
+		constraints = append(constraints, v)
+	}
+	if exact != nil {
+		for _, c := range constraints {
+			if sc, _ := semver.NewConstraint(c); !sc.Check(exact) {
+				return "", versionConflict(name, versions)
+			}
+		}
+		return exactStr, nil
+	}
+	version := intersectConstraints(constraints)
+	if len(constraints) > 1 && version == "" {
+		return "", versionConflict(name, versions)
+	}
+	return version, nil
+}
+
+// intersectConstraints drops combinations of alternatives that no version satisfies,
+// so an empty string is returned for multiple constraints with an empty intersection.
+func intersectConstraints(constraints []string) string {
+	if len(constraints) < 2 {
+		return strings.Join(constraints, "")
+	}
+	out := []string{""}
+	for _, c := range constraints {
+		var next []string
+		for _, prefix := range out {
+			for _, alt := range strings.Split(c, "||") {
+				alt = strings.TrimSpace(alt)
+				if prefix != "" {
+					alt = prefix + ", " + alt
+				}
+				next = append(next, alt)
+			}
+		}
+		out = next
+	}
+	var valid []string
+	for _, c := range out {
+		if satisfiable(c) {
+			valid = append(valid, c)
+		}
+	}
+	return strings.Join(valid, " || ")
+}
+
+var versionPattern = regexp.MustCompile(`\d+(\.(\d+|[xX*]))?(\.(\d+|[xX*]))?(-[0-9A-Za-z.-]+)?`)
+
+// satisfiable checks the lowest versions that could satisfy each bound in the constraint.
+// The lowest version satisfying an intersection of ranges is always one of these candidates.
+func satisfiable(constraint string) bool {
+	c, err := semver.NewConstraint(constraint)
+	if err != nil {
+		return false
+	}
+	candidates := []string{"0.0.0"}
+	for _, m := range versionPattern.FindAllString(constraint, -1) {
+		candidates = append(candidates, strings.NewReplacer("x", "0", "X", "0", "*", "0").Replace(m))
+	}
+	for _, s := range candidates {
+		v, err := semver.NewVersion(s)
+		if err != nil {
+			continue
+		}
+		for _, next := range []semver.Version{*v, v.IncPatch(), v.IncMinor(), v.IncMajor()} {
+			if c.Check(&next) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func versionConflict(name string, versions []string) error {
+	return xerrors.Errorf("conflicting version requirements for '%s': %s", name, strings.Join(versions, ", "))
+}
+
+func appendUnique(list []string, s string) []string {
+	for _, v := range list {
+		if v == s {
+			return list
+		}
+	}
+	return append(list, s)
+}
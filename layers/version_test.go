@@ -0,0 +1,37 @@
+package layers
+
+import (
+	"testing"
+
+	"github.com/sclevine/packfile/link"
+)
+
+// TestMergeVersions checks merged versions for duplicate requires
+func TestMergeVersions(t *testing.T) {
+	for _, tt := range []struct {
+		versions []string
+		version  string
+		fail     bool
+	}{
+		{versions: []string{"1.2.3"}, version: "1.2.3"},
+		{versions: []string{"1.x", "1.2.3"}, version: "1.2.3"},
+		{versions: []string{"1.2.3", "1.2.4"}, fail: true},
+		{versions: []string{"1.x", "2.x"}, fail: true},
+		{versions: []string{"A", "B", "A"}, version: "A"},
+		{versions: []string{"1.x", "latest", "1.x"}, version: "1.x"},
+		{versions: []string{"", "*", ""}, version: ""},
+	} {
+		var reqs []link.Require
+		for _, v := range tt.versions {
+			reqs = append(reqs, link.Require{Name: "node", Version: v})
+		}
+		version, err := mergeVersions("node", reqs)
+		if tt.fail {
+			if err == nil {
+				t.Errorf("expected error for %v, got: %s", tt.versions, version)
+			}
+		} else if err != nil || version != tt.version {
+			t.Errorf("expected '%s' for %v, got: '%s', %v", tt.version, tt.versions, version, err)
+		}
+	}
+}
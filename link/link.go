@@ -32,6 +32,7 @@ type Info struct {
 	Share *Share
 	Links []packfile.Link
 	App   bool
+	Cache bool
 }
 
 type Share struct {
@@ -50,6 +51,7 @@ func Layers(layers []Layer) {
 
 type Require struct {
 	Name     string                 `toml:"name"`
+	Version  string                 `toml:"version,omitempty"`
 	Metadata map[string]interface{} `toml:"metadata"`
 }
 
@@ -63,7 +65,7 @@ func Requires(layers []Layer) ([]Require, error) {
 		} else if err != nil {
 			return nil, xerrors.Errorf("error for layer '%s': %w", info.Name, err)
 		}
-		if info.Share.Metadata == nil {
+		if info.Share.Metadata == nil || info.Cache {
 			continue
 		}
 		req, err := readRequire(info.Name, info.Share.Metadata)
@@ -0,0 +1,89 @@
+package metadata
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+type Change struct {
+	Key string
+	Old interface{}
+	New interface{}
+}
+
+func (c Change) String() string {
+	return fmt.Sprintf("%s: %s -> %s", c.Key, changeValue(c.Old), changeValue(c.New))
+}
+
+func changeValue(v interface{}) string {
+	if v == nil {
+		return "(none)"
+	}
+	if l, ok := v.([]string); ok {
+		return "[" + strings.Join(l, ", ") + "]"
+	}
+	return fmt.Sprintf("%q", formatValue(v))
+}
+
+// Diff compares two sets of metadata, such as saved and current metadata.
+// Nested keys are joined with '.', and changes are sorted by key.
+func Diff(old, new map[string]interface{}) []Change {
+	var out []Change
+	diffMaps(&out, flatten(old), flatten(new))
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Key < out[j].Key
+	})
+	return out
+}
+
+func diffMaps(out *[]Change, old, new map[string]interface{}) {
+	for k, ov := range old {
+		nv, ok := new[k]
+		if !ok {
+			*out = append(*out, Change{k, ov, nil})
+		} else if !reflect.DeepEqual(ov, nv) {
+			*out = append(*out, Change{k, ov, nv})
+		}
+	}
+	for k, nv := range new {
+		if _, ok := old[k]; !ok {
+			*out = append(*out, Change{k, nil, nv})
+		}
+	}
+}
+
+func flatten(m map[string]interface{}) map[string]interface{} {
+	out := map[string]interface{}{}
+	for k, v := range m {
+		nv, err := normalize(v)
+		if err != nil {
+			nv = fmt.Sprint(v)
+		}
+		if nm, ok := nv.(map[string]interface{}); ok {
+			for nk, nv := range flatten(nm) {
+				out[k+"."+nk] = nv
+			}
+			continue
+		}
+		out[k] = nv
+	}
+	return out
+}
+
+// Lookup finds a value using a key with nested keys joined by '.'
+func Lookup(m map[string]interface{}, key string) (interface{}, bool) {
+	if v, ok := m[key]; ok {
+		return v, true
+	}
+	parts := strings.SplitN(key, ".", 2)
+	if len(parts) < 2 {
+		return nil, false
+	}
+	nm, ok := m[parts[0]].(map[string]interface{})
+	if !ok {
+		return nil, false
+	}
+	return Lookup(nm, parts[1])
+}
@@ -1,78 +1,188 @@
 package metadata
 
 import (
-	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
+)
 
-	"github.com/BurntSushi/toml"
+const (
+	jsonExt   = ".json"
+	typeExt   = ".type"
+	tmpPrefix = ".tmp."
 )
 
+// fsLocks is shared by all stores for the same directory in this process
+var fsLocks = struct {
+	sync.Mutex
+	m map[string]*sync.RWMutex
+}{m: map[string]*sync.RWMutex{}}
+
 func NewFS(path string) Metadata {
-	return fsStore{path}
+	path = filepath.Clean(path)
+	fsLocks.Lock()
+	defer fsLocks.Unlock()
+	mu, ok := fsLocks.m[path]
+	if !ok {
+		mu = &sync.RWMutex{}
+		fsLocks.m[path] = mu
+	}
+	return fsStore{path, mu}
 }
 
 type fsStore struct {
 	path string
+	mu   *sync.RWMutex
 }
 
 func (fs fsStore) Read(keys ...string) (string, error) {
-	if len(keys) == 0 {
-		return "", ErrNoKeys
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+	v, err := fs.readValue(keys...)
+	if err != nil {
+		return "", err
+	}
+	if _, ok := v.(map[string]interface{}); ok {
+		return "", ErrNotValue
+	}
+	return formatValue(v), nil
+}
+
+func (fs fsStore) readValue(keys ...string) (interface{}, error) {
+	if err := validateKeys(keys); err != nil {
+		return nil, err
+	}
+	v, err := readPath(fs.keyPath(keys...))
+	if err == ErrNotExist {
+		return fs.readJSON(keys...)
 	}
-	value, err := ioutil.ReadFile(fs.keyPath(keys...))
+	return v, err
+}
+
+func readPath(path string) (interface{}, error) {
+	value, err := ioutil.ReadFile(path)
 	if os.IsNotExist(err) {
-		return "", ErrNotExist
+		return nil, ErrNotExist
 	} else if err != nil {
-		return "", err
+		return nil, err
+	}
+	typ, err := ioutil.ReadFile(typePath(path))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return parseValue(string(typ), string(value)), nil
+}
+
+// readJSON finds keys inside of the nearest <key>.json file
+func (fs fsStore) readJSON(keys ...string) (interface{}, error) {
+	for i := len(keys); i > 0; i-- {
+		data, err := ioutil.ReadFile(fs.keyPath(keys[:i]...) + jsonExt)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		v, err := decodeJSON(data)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range keys[i:] {
+			m, ok := v.(map[string]interface{})
+			if !ok {
+				return nil, ErrNotExist
+			}
+			if v, ok = m[key]; !ok {
+				return nil, ErrNotExist
+			}
+		}
+		return v, nil
 	}
-	return strings.TrimSuffix(string(value), "\n"), nil
+	return nil, ErrNotExist
 }
 
+// ReadAll does not observe partial writes made through stores for the same directory
 func (fs fsStore) ReadAll() (map[string]interface{}, error) {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
 	metadata := map[string]interface{}{}
-	return metadata, fs.eachFile(metadata, nil, func(m map[string]interface{}, keys ...string) error {
-		var err error
-		m[keys[len(keys)-1]], err = fs.Read(keys...)
-		return err
-	})
+	return metadata, eachFile(metadata, fs.path)
 }
 
-func (fs fsStore) eachFile(m map[string]interface{}, start []string, fn func(m map[string]interface{}, keys ...string) error) error {
-	files, err := ioutil.ReadDir(fs.keyPath(start...))
+func eachFile(m map[string]interface{}, dir string) error {
+	files, err := ioutil.ReadDir(dir)
 	if err != nil {
 		return err
 	}
+	var jsonFiles []string
 	for _, f := range files {
 		name := f.Name()
-		if len(name) >  0 && name[0] == '.' {
+		if len(name) > 0 && name[0] == '.' {
 			continue
 		}
+		path := filepath.Join(dir, name)
 		if f.IsDir() {
 			n := map[string]interface{}{}
-			m[name] = n
-			if err := fs.eachFile(n, append(start, name), fn); err != nil {
+			m[unescapeKey(name)] = n
+			if err := eachFile(n, path); err != nil {
 				return err
 			}
+		} else if strings.HasSuffix(name, jsonExt) {
+			jsonFiles = append(jsonFiles, name)
 		} else {
-			if err := fn(m, append(start, name)...); err != nil {
+			if m[unescapeKey(name)], err = readPath(path); err != nil {
 				return err
 			}
 		}
 	}
+	for _, name := range jsonFiles {
+		data, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return err
+		}
+		v, err := decodeJSON(data)
+		if err != nil {
+			m[unescapeKey(name)] = string(data)
+			continue
+		}
+		key := unescapeKey(strings.TrimSuffix(name, jsonExt))
+		dm, dok := m[key].(map[string]interface{})
+		vm, vok := v.(map[string]interface{})
+		if dok && vok {
+			mergeMaps(dm, vm)
+		} else {
+			m[key] = v
+		}
+	}
 	return nil
 }
 
 func (fs fsStore) Delete(keys ...string) error {
-	if len(keys) == 0 {
-		return ErrNoKeys
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return fs.delete(keys...)
+}
+
+func (fs fsStore) delete(keys ...string) error {
+	if err := validateKeys(keys); err != nil {
+		return err
+	}
+	return deletePath(fs.keyPath(keys...))
+}
+
+func deletePath(path string) error {
+	for _, p := range []string{typePath(path), path + jsonExt} {
+		if err := os.RemoveAll(p); err != nil {
+			return err
+		}
 	}
-	return os.RemoveAll(fs.keyPath(keys...))
+	return os.RemoveAll(path)
 }
 
 func (fs fsStore) DeleteAll() error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
 	files, err := ioutil.ReadDir(fs.path)
 	if err != nil {
 		return err
@@ -82,7 +192,7 @@ func (fs fsStore) DeleteAll() error {
 		if len(name) > 0 && name[0] == '.' {
 			continue
 		}
-		if err := fs.Delete(name); err != nil {
+		if err := deletePath(filepath.Join(fs.path, name)); err != nil {
 			return err
 		}
 	}
@@ -90,75 +200,87 @@ func (fs fsStore) DeleteAll() error {
 }
 
 func (fs fsStore) Write(value string, keys ...string) error {
-	if len(keys) == 0 {
-		return ErrNoKeys
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return fs.writeValue(value, keys...)
+}
+
+// writeValue replaces files by renaming, so that readers in other processes never see partial values
+func (fs fsStore) writeValue(value interface{}, keys ...string) error {
+	if err := validateKeys(keys); err != nil {
+		return err
+	}
+	path := fs.keyPath(keys...)
+	if fi, err := os.Lstat(path); err == nil && fi.IsDir() {
+		if err := os.RemoveAll(path); err != nil {
+			return err
+		}
 	}
-	if err := fs.Delete(keys...); err != nil {
+	if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
 		return ErrNotKey
 	}
-	if len(keys) > 1 {
-		if err := os.MkdirAll(filepath.Dir(fs.keyPath(keys...)), 0777); err != nil {
+	if err := os.RemoveAll(path + jsonExt); err != nil {
+		return err
+	}
+	if typ := valueType(value); typ != "" {
+		if err := writeFileAtomic(typePath(path), []byte(typ)); err != nil {
 			return err
 		}
+	} else if err := os.RemoveAll(typePath(path)); err != nil {
+		return err
 	}
-	return ioutil.WriteFile(fs.keyPath(keys...), []byte(value), 0666)
+	return writeFileAtomic(path, []byte(encodeValue(value)))
+}
+
+func writeFileAtomic(path string, data []byte) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), tmpPrefix)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(f.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
 }
 
 func (fs fsStore) keyPath(keys ...string) string {
-	return filepath.Join(append([]string{fs.path}, keys...)...)
+	path := fs.path
+	for _, key := range keys {
+		path = filepath.Join(path, escapeKey(key))
+	}
+	return path
+}
+
+// typePath stores the type of non-string values next to the value, hidden from ReadAll
+func typePath(path string) string {
+	return filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+typeExt)
 }
 
+// WriteAll validates every key and value before writing any of them.
+// Values are written one at a time, so a failed write may leave earlier values written.
 func (fs fsStore) WriteAll(metadata map[string]interface{}) error {
-	return eachKey(metadata, nil, func(value string, keys ...string) error {
-		return fs.Write(value, keys...)
+	values, err := normalize(metadata)
+	if err != nil {
+		return err
+	}
+	if err := validateAll(values.(map[string]interface{})); err != nil {
+		return err
+	}
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return eachKey(values.(map[string]interface{}), nil, func(value interface{}, keys ...string) error {
+		return fs.writeValue(value, keys...)
 	})
 }
 
 func (fs fsStore) Dir() string {
 	return fs.path
 }
-
-func eachKey(m map[string]interface{}, start []string, fn func(v string, keys ...string) error) error {
-	for k, v := range m {
-		switch v := v.(type) {
-		case map[string]interface{}:
-			if err := eachKey(v, append(start, k), fn); err != nil {
-				return err
-			}
-		default:
-			s, err := primToString(v)
-			if err != nil {
-				return err
-			}
-			if err := fn(s, append(start, k)...); err != nil {
-				return err
-			}
-		}
-	}
-	return nil
-}
-
-func primToString(v interface{}) (string, error) {
-	switch v := v.(type) {
-	case toml.TextMarshaler:
-		text, err := v.MarshalText()
-		if err != nil {
-			return "", err
-		}
-		return string(text), nil
-	case fmt.Stringer:
-		return v.String(), nil
-	case string:
-		return v, nil
-	case bool:
-		return fmt.Sprintf("%t", v), nil
-	case int64:
-		return fmt.Sprintf("%d", v), nil
-	case float64:
-		return fmt.Sprintf("%f", v), nil
-	case nil:
-		return "", ErrNotExist
-	default:
-		return "", ErrNotValue
-	}
-}
\ No newline at end of file
@@ -0,0 +1,76 @@
+package metadata
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"golang.org/x/xerrors"
+)
+
+// validateKeys also rejects names used by fsStore for type sidecars and temporary files
+func validateKeys(keys []string) error {
+	if len(keys) == 0 {
+		return ErrNoKeys
+	}
+	for _, key := range keys {
+		switch {
+		case key == "", key == ".", key == "..",
+			strings.HasPrefix(key, tmpPrefix),
+			strings.HasPrefix(key, ".") && strings.HasSuffix(key, typeExt):
+			return xerrors.Errorf("key '%s': %w", key, ErrInvalidKey)
+		}
+	}
+	return nil
+}
+
+// validateAll checks the keys of every value in m
+func validateAll(m map[string]interface{}) error {
+	return eachKey(m, nil, func(_ interface{}, keys ...string) error {
+		return validateKeys(keys)
+	})
+}
+
+// escapeKey maps any key to a single file name by percent-encoding '%', path separators, and NUL.
+// The '.' of a trailing ".json" is also encoded so that values are not confused with JSON files.
+// Keys that begin with '.' are left hidden.
+func escapeKey(key string) string {
+	var b strings.Builder
+	for i := 0; i < len(key); i++ {
+		switch c := key[i]; c {
+		case '%', '/', '\\', 0:
+			fmt.Fprintf(&b, "%%%02X", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	out := b.String()
+	if strings.HasSuffix(out, jsonExt) && len(out) > len(jsonExt) {
+		out = strings.TrimSuffix(out, jsonExt) + "%2E" + jsonExt[1:]
+	}
+	return out
+}
+
+// unescapeKey reverses escapeKey, returning names that are not valid escapes unmodified
+func unescapeKey(name string) string {
+	if !strings.Contains(name, "%") {
+		return name
+	}
+	var b strings.Builder
+	for i := 0; i < len(name); i++ {
+		if name[i] != '%' {
+			b.WriteByte(name[i])
+			continue
+		}
+		if i+2 >= len(name) {
+			return name
+		}
+		c, err := strconv.ParseUint(name[i+1:i+3], 16, 8)
+		if err != nil {
+			return name
+		}
+		b.WriteByte(byte(c))
+		i += 2
+	}
+	return b.String()
+}
@@ -1,17 +1,22 @@
 package metadata
 
+import "sync"
+
 func NewMemory() Metadata {
-	return memStore{m: map[string]interface{}{}}
+	return &memStore{m: map[string]interface{}{}}
 }
 
 type memStore struct {
-	m map[string]interface{}
+	mu sync.RWMutex
+	m  map[string]interface{}
 }
 
-func (ms memStore) Read(keys ...string) (string, error) {
-	if len(keys) == 0 {
-		return "", ErrNoKeys
+func (ms *memStore) Read(keys ...string) (string, error) {
+	if err := validateKeys(keys); err != nil {
+		return "", err
 	}
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
 	m, err := ms.getMap(keys[:len(keys)-1])
 	if err != nil {
 		return "", err
@@ -19,12 +24,17 @@ func (ms memStore) Read(keys ...string) (string, error) {
 	switch t := m[keys[len(keys)-1]].(type) {
 	case map[string]interface{}:
 		return "", ErrNotValue
+	case nil:
+		return "", ErrNotExist
 	default:
-		return primToString(t)
+		return formatValue(t), nil
 	}
 }
 
-func (ms memStore) ReadAll() (map[string]interface{}, error) {
+// ReadAll returns a snapshot that is not affected by later writes
+func (ms *memStore) ReadAll() (map[string]interface{}, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
 	return copyMap(ms.m), nil
 }
 
@@ -34,19 +44,24 @@ func copyMap(m map[string]interface{}) map[string]interface{} {
 		if len(k) > 0 && k[0] == '.' {
 			continue
 		}
-		if vm, ok := v.(map[string]interface{}); ok {
-			out[k] = copyMap(vm)
-		} else {
+		switch v := v.(type) {
+		case map[string]interface{}:
+			out[k] = copyMap(v)
+		case []string:
+			out[k] = append([]string{}, v...)
+		default:
 			out[k] = v
 		}
 	}
 	return out
 }
 
-func (ms memStore) Delete(keys ...string) error {
-	if len(keys) == 0 {
-		return ErrNoKeys
+func (ms *memStore) Delete(keys ...string) error {
+	if err := validateKeys(keys); err != nil {
+		return err
 	}
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
 	m, err := ms.getMap(keys[:len(keys)-1])
 	if err != nil {
 		return nil
@@ -55,7 +70,9 @@ func (ms memStore) Delete(keys ...string) error {
 	return nil
 }
 
-func (ms memStore) DeleteAll() error {
+func (ms *memStore) DeleteAll() error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
 	for k := range ms.m {
 		if len(k) == 0 || k[0] != '.' {
 			delete(ms.m, k)
@@ -64,9 +81,15 @@ func (ms memStore) DeleteAll() error {
 	return nil
 }
 
-func (ms memStore) Write(value string, keys ...string) error {
-	if len(keys) == 0 {
-		return ErrNoKeys
+func (ms *memStore) Write(value string, keys ...string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	return ms.writeValue(value, keys...)
+}
+
+func (ms *memStore) writeValue(value interface{}, keys ...string) error {
+	if err := validateKeys(keys); err != nil {
+		return err
 	}
 	m, err := ms.createMap(keys[:len(keys)-1])
 	if err != nil {
@@ -76,13 +99,41 @@ func (ms memStore) Write(value string, keys ...string) error {
 	return nil
 }
 
-func (ms memStore) WriteAll(metadata map[string]interface{}) error {
-	return eachKey(metadata, nil, func(value string, keys ...string) error {
-		return ms.Write(value, keys...)
-	})
+// WriteAll applies all values or none of them
+func (ms *memStore) WriteAll(metadata map[string]interface{}) error {
+	values, err := normalize(metadata)
+	if err != nil {
+		return err
+	}
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	next := deepCopy(ms.m)
+	if err := eachKey(values.(map[string]interface{}), nil, func(value interface{}, keys ...string) error {
+		return (&memStore{m: next}).writeValue(value, keys...)
+	}); err != nil {
+		return err
+	}
+	ms.m = next
+	return nil
+}
+
+// deepCopy copies hidden values as well as visible values
+func deepCopy(m map[string]interface{}) map[string]interface{} {
+	out := map[string]interface{}{}
+	for k, v := range m {
+		switch v := v.(type) {
+		case map[string]interface{}:
+			out[k] = deepCopy(v)
+		case []string:
+			out[k] = append([]string{}, v...)
+		default:
+			out[k] = v
+		}
+	}
+	return out
 }
 
-func (ms memStore) getMap(keys []string) (map[string]interface{}, error) {
+func (ms *memStore) getMap(keys []string) (map[string]interface{}, error) {
 	m := ms.m
 	for _, key := range keys {
 		switch t := m[key].(type) {
@@ -95,7 +146,7 @@ func (ms memStore) getMap(keys []string) (map[string]interface{}, error) {
 	return m, nil
 }
 
-func (ms memStore) createMap(keys []string) (map[string]interface{}, error) {
+func (ms *memStore) createMap(keys []string) (map[string]interface{}, error) {
 	m := ms.m
 	for _, key := range keys {
 		switch t := m[key].(type) {
@@ -110,4 +161,4 @@ func (ms memStore) createMap(keys []string) (map[string]interface{}, error) {
 		}
 	}
 	return m, nil
-}
\ No newline at end of file
+}
@@ -3,13 +3,18 @@ package metadata
 import "golang.org/x/xerrors"
 
 var (
-	ErrNoKeys   = xerrors.New("no keys provided")
-	ErrNotValue = xerrors.New("not a value")
-	ErrNotKey   = xerrors.New("not a key")
-	ErrNotExist = xerrors.New("does not exist")
+	ErrNoKeys     = xerrors.New("no keys provided")
+	ErrNotValue   = xerrors.New("not a value")
+	ErrNotKey     = xerrors.New("not a key")
+	ErrNotExist   = xerrors.New("does not exist")
+	ErrReadOnly   = xerrors.New("read-only")
+	ErrInvalidKey = xerrors.New("invalid key")
 )
 
 // Metadata ignores values that begin with '.' for ReadAll and DeleteAll
+// Keys may be any string except "", ".", and ".." (ErrInvalidKey).
+// WriteAll preserves bool, int64, float64, []string, and nested table values, which are returned by ReadAll.
+// Read returns the text form of any value, with list elements separated by newlines.
 type Metadata interface {
 	Read(keys ...string) (string, error)
 	ReadAll() (map[string]interface{}, error)
@@ -22,4 +27,3 @@ type Metadata interface {
 	Write(value string, keys ...string) error
 	WriteAll(metadata map[string]interface{}) error
 }
-
@@ -0,0 +1,206 @@
+package metadata_test
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+
+	"golang.org/x/xerrors"
+
+	"github.com/sclevine/packfile/metadata"
+)
+
+type store struct {
+	name string
+	new  func(t *testing.T) (md, linked metadata.Metadata, done func())
+}
+
+var stores = []store{
+	{"fs", func(t *testing.T) (metadata.Metadata, metadata.Metadata, func()) {
+		dir, err := ioutil.TempDir("", "metadata-test.")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return metadata.NewFS(dir), metadata.NewReadOnly(metadata.NewFS(dir)), func() { os.RemoveAll(dir) }
+	}},
+	{"memory", func(*testing.T) (metadata.Metadata, metadata.Metadata, func()) {
+		md := metadata.NewMemory()
+		return md, metadata.NewReadOnly(md), func() {}
+	}},
+}
+
+// TestLinkedReaders checks that linked readers never observe a partially-applied WriteAll
+func TestLinkedReaders(t *testing.T) {
+	for _, s := range stores {
+		t.Run(s.name, func(t *testing.T) {
+			md, linked, done := s.new(t)
+			defer done()
+			snapshotDir, err := ioutil.TempDir("", "metadata-test.")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(snapshotDir)
+
+			values := func(i int) map[string]interface{} {
+				n := strconv.Itoa(i)
+				return map[string]interface{}{
+					"a":    n,
+					"deps": map[string]interface{}{"b": n, "c": []string{n, n}},
+				}
+			}
+			if err := md.WriteAll(values(0)); err != nil {
+				t.Fatal(err)
+			}
+			check := func(m map[string]interface{}) {
+				a, _ := m["a"].(string)
+				deps, _ := m["deps"].(map[string]interface{})
+				b, _ := deps["b"].(string)
+				c, _ := deps["c"].([]string)
+				if a == "" || b != a || len(c) != 2 || c[0] != a || c[1] != a {
+					t.Errorf("inconsistent read: %#v", m)
+				}
+			}
+
+			var wg sync.WaitGroup
+			wg.Add(4)
+			go func() {
+				defer wg.Done()
+				for i := 1; i <= 50; i++ {
+					if err := md.WriteAll(values(i)); err != nil {
+						t.Error(err)
+						return
+					}
+				}
+			}()
+			for i := 0; i < 2; i++ {
+				go func() {
+					defer wg.Done()
+					for j := 0; j < 50; j++ {
+						m, err := linked.ReadAll()
+						if err != nil {
+							t.Error(err)
+							return
+						}
+						check(m)
+					}
+				}()
+			}
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 20; j++ {
+					path, err := metadata.Snapshot(linked, snapshotDir)
+					if err != nil {
+						t.Error(err)
+						return
+					}
+					m, err := metadata.NewFS(path).ReadAll()
+					if err != nil {
+						t.Error(err)
+						return
+					}
+					check(m)
+				}
+			}()
+			wg.Wait()
+
+			if err := linked.Write("x", "a"); !xerrors.Is(err, metadata.ErrReadOnly) {
+				t.Errorf("expected ErrReadOnly, got: %v", err)
+			}
+		})
+	}
+}
+
+// TestWriteAllInvalidKey checks that WriteAll writes nothing when any key is invalid
+func TestWriteAllInvalidKey(t *testing.T) {
+	for _, s := range stores {
+		t.Run(s.name, func(t *testing.T) {
+			md, _, done := s.new(t)
+			defer done()
+			err := md.WriteAll(map[string]interface{}{"a": "1", "..": "2", "b": "3", "c": "4"})
+			if !xerrors.Is(err, metadata.ErrInvalidKey) {
+				t.Fatalf("expected ErrInvalidKey, got: %v", err)
+			}
+			m, err := md.ReadAll()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(m) != 0 {
+				t.Errorf("expected no values, got: %#v", m)
+			}
+		})
+	}
+}
+
+// TestRoundTrip checks that escaped keys and typed values are read back as written
+func TestRoundTrip(t *testing.T) {
+	values := map[string]interface{}{
+		"@scope/pkg": "1.0.0",
+		"a%2Fb":      "escaped",
+		"a/b":        "slash",
+		"c.json":     "not json",
+		`d\e`:       "backslash",
+		"deps": map[string]interface{}{
+			"@scope/pkg": map[string]interface{}{"version": "2.0.0", "dev": true},
+			"%":          "percent",
+		},
+		"string":    "text",
+		"empty":     "",
+		"bool":      true,
+		"int":       int64(-3),
+		"float":     1.5,
+		"list":      []string{"a", "b"},
+		"emptyList": []string{},
+		"blankList": []string{""},
+		"trailing":  []string{"a", ""},
+	}
+	for _, s := range stores {
+		t.Run(s.name, func(t *testing.T) {
+			md, linked, done := s.new(t)
+			defer done()
+			if err := md.WriteAll(values); err != nil {
+				t.Fatal(err)
+			}
+			for name, m := range map[string]metadata.Metadata{"store": md, "linked": linked} {
+				out, err := m.ReadAll()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !reflect.DeepEqual(out, values) {
+					t.Errorf("%s: expected %#v, got: %#v", name, values, out)
+				}
+			}
+			for _, keys := range [][]string{{"@scope/pkg"}, {"a%2Fb"}, {"deps", "@scope/pkg", "version"}} {
+				if err := md.Delete(keys...); err != nil {
+					t.Fatal(err)
+				}
+				if _, err := md.Read(keys...); !xerrors.Is(err, metadata.ErrNotExist) {
+					t.Errorf("expected %v to be deleted, got: %v", keys, err)
+				}
+			}
+			if v, err := md.Read("a/b"); err != nil || v != "slash" {
+				t.Errorf("expected unescaped key to remain, got: %s, %v", v, err)
+			}
+		})
+	}
+}
+
+// TestReservedKeys checks that keys that collide with fsStore type sidecars and temporary files are rejected
+func TestReservedKeys(t *testing.T) {
+	for _, s := range stores {
+		t.Run(s.name, func(t *testing.T) {
+			md, _, done := s.new(t)
+			defer done()
+			for _, keys := range [][]string{{".a.type"}, {"a", ".b.type"}, {".tmp.a"}, {"a", ".tmp.b"}} {
+				if err := md.Write("x", keys...); !xerrors.Is(err, metadata.ErrInvalidKey) {
+					t.Errorf("expected ErrInvalidKey for %v, got: %v", keys, err)
+				}
+			}
+			if err := md.Write("x", ".a"); err != nil {
+				t.Errorf("expected hidden key to be valid, got: %v", err)
+			}
+		})
+	}
+}
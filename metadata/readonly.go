@@ -0,0 +1,65 @@
+package metadata
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// NewReadOnly returns a view of md that rejects all modifications with ErrReadOnly
+func NewReadOnly(md Metadata) Metadata {
+	return readOnly{md}
+}
+
+type readOnly struct {
+	md Metadata
+}
+
+func (ro readOnly) Read(keys ...string) (string, error) {
+	return ro.md.Read(keys...)
+}
+
+func (ro readOnly) ReadAll() (map[string]interface{}, error) {
+	return ro.md.ReadAll()
+}
+
+func (readOnly) Delete(...string) error {
+	return ErrReadOnly
+}
+
+func (readOnly) DeleteAll() error {
+	return ErrReadOnly
+}
+
+func (readOnly) Write(string, ...string) error {
+	return ErrReadOnly
+}
+
+func (readOnly) WriteAll(map[string]interface{}) error {
+	return ErrReadOnly
+}
+
+// Snapshot copies md into a new directory under dir with read-only files.
+// Changes made to the copy are never reflected in md.
+func Snapshot(md Metadata, dir string) (string, error) {
+	values, err := md.ReadAll()
+	if err != nil {
+		return "", err
+	}
+	path, err := ioutil.TempDir(dir, "md.")
+	if err != nil {
+		return "", err
+	}
+	if err := NewFS(path).WriteAll(values); err != nil {
+		return "", err
+	}
+	return path, filepath.Walk(path, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() {
+			return nil
+		}
+		return os.Chmod(p, 0444)
+	})
+}
@@ -0,0 +1,203 @@
+package metadata
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+const (
+	typeBool  = "bool"
+	typeInt   = "int"
+	typeFloat = "float"
+	typeList  = "list"
+)
+
+// normalize converts v to one of: string, bool, int64, float64, []string, or map[string]interface{}
+func normalize(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		out := map[string]interface{}{}
+		for k, e := range v {
+			ne, err := normalize(e)
+			if err != nil {
+				return nil, err
+			}
+			out[k] = ne
+		}
+		return out, nil
+	case []string:
+		return append([]string{}, v...), nil
+	case []interface{}:
+		out := []string{}
+		for _, e := range v {
+			ne, err := normalize(e)
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := ne.(map[string]interface{}); ok {
+				return nil, ErrNotValue
+			}
+			if _, ok := ne.([]string); ok {
+				return nil, ErrNotValue
+			}
+			out = append(out, formatValue(ne))
+		}
+		return out, nil
+	case string, bool, int64, float64:
+		return v, nil
+	case int:
+		return int64(v), nil
+	case int32:
+		return int64(v), nil
+	case uint:
+		return int64(v), nil
+	case uint32:
+		return int64(v), nil
+	case uint64:
+		return int64(v), nil
+	case float32:
+		return float64(v), nil
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i, nil
+		}
+		return v.Float64()
+	case toml.TextMarshaler:
+		text, err := v.MarshalText()
+		if err != nil {
+			return nil, err
+		}
+		return string(text), nil
+	case fmt.Stringer:
+		return v.String(), nil
+	case nil:
+		return nil, ErrNotExist
+	default:
+		return nil, ErrNotValue
+	}
+}
+
+func valueType(v interface{}) string {
+	switch v.(type) {
+	case bool:
+		return typeBool
+	case int64:
+		return typeInt
+	case float64:
+		return typeFloat
+	case []string:
+		return typeList
+	default:
+		return ""
+	}
+}
+
+// formatValue returns the text form of a normalized value, with list elements separated by newlines
+func formatValue(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case bool:
+		return strconv.FormatBool(v)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	case []string:
+		return strings.Join(v, "\n")
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// encodeValue returns the file contents for a normalized value, with each list element terminated by a newline,
+// so that empty lists and lists with empty elements are distinct
+func encodeValue(v interface{}) string {
+	if l, ok := v.([]string); ok && len(l) > 0 {
+		return strings.Join(l, "\n") + "\n"
+	}
+	return formatValue(v)
+}
+
+// parseValue reverses encodeValue, falling back to a string if the text no longer matches the type.
+// A single trailing newline is ignored, so that values written by scripts (e.g., with echo) are read as expected.
+func parseValue(typ, s string) interface{} {
+	if typ == typeList {
+		if s == "" {
+			return []string{}
+		}
+		return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+	}
+	s = strings.TrimSuffix(s, "\n")
+	switch typ {
+	case typeBool:
+		if b, err := strconv.ParseBool(s); err == nil {
+			return b
+		}
+	case typeInt:
+		if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return i
+		}
+	case typeFloat:
+		if f, err := strconv.ParseFloat(s, 64); err == nil {
+			return f
+		}
+	}
+	return s
+}
+
+func primToString(v interface{}) (string, error) {
+	v, err := normalize(v)
+	if err != nil {
+		return "", err
+	}
+	if _, ok := v.(map[string]interface{}); ok {
+		return "", ErrNotValue
+	}
+	return formatValue(v), nil
+}
+
+func eachKey(m map[string]interface{}, start []string, fn func(v interface{}, keys ...string) error) error {
+	for k, v := range m {
+		nv, err := normalize(v)
+		if err != nil {
+			return err
+		}
+		if nm, ok := nv.(map[string]interface{}); ok {
+			if err := eachKey(nm, append(start, k), fn); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := fn(nv, append(start, k)...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func decodeJSON(data []byte) (interface{}, error) {
+	dec := json.NewDecoder(strings.NewReader(string(data)))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	return normalize(v)
+}
+
+func mergeMaps(dst, src map[string]interface{}) {
+	for k, v := range src {
+		dm, dok := dst[k].(map[string]interface{})
+		sm, sok := v.(map[string]interface{})
+		if dok && sok {
+			mergeMaps(dm, sm)
+		} else {
+			dst[k] = v
+		}
+	}
+}
@@ -0,0 +1,233 @@
+package pf
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ulikunitz/xz"
+	"golang.org/x/xerrors"
+)
+
+// Extract unpacks a tar (optionally gzip, bzip2, or xz-compressed) or zip archive into dir.
+// The first strip components of each path are removed, like tar --strip-components.
+// Entries are never written through symlinks, and links must not point outside of dir.
+func Extract(path, dir string, strip int) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	br := bufio.NewReader(f)
+	magic, err := br.Peek(6)
+	if err != nil && err != io.EOF {
+		return err
+	}
+	var r io.Reader = br
+	switch {
+	case bytes.HasPrefix(magic, []byte("PK\x03\x04")):
+		return extractZip(f, dir, strip)
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		gr, err := gzip.NewReader(br)
+		if err != nil {
+			return err
+		}
+		defer gr.Close()
+		r = gr
+	case bytes.HasPrefix(magic, []byte("BZh")):
+		r = bzip2.NewReader(br)
+	case bytes.HasPrefix(magic, []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}):
+		if r, err = xz.NewReader(br); err != nil {
+			return err
+		}
+	}
+	return extractTar(r, dir, strip)
+}
+
+func extractTar(r io.Reader, dir string, strip int) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		path, err := extractPath(dir, hdr.Name, strip)
+		if err != nil {
+			return err
+		}
+		if path == "" {
+			continue
+		}
+		if err := prepareEntry(dir, path); err != nil {
+			return err
+		}
+		mode := hdr.FileInfo().Mode()
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(path, mode.Perm()|0700)
+		case tar.TypeReg, tar.TypeRegA:
+			err = writeFile(path, tr, mode.Perm())
+		case tar.TypeSymlink:
+			err = extractSymlink(dir, hdr.Linkname, path)
+		case tar.TypeLink:
+			var target string
+			if target, err = extractPath(dir, hdr.Linkname, strip); err == nil && target != "" {
+				err = extractHardlink(dir, target, path)
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func extractZip(f *os.File, dir string, strip int) error {
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	zr, err := zip.NewReader(f, fi.Size())
+	if err != nil {
+		return err
+	}
+	for _, zf := range zr.File {
+		path, err := extractPath(dir, zf.Name, strip)
+		if err != nil {
+			return err
+		}
+		if path == "" {
+			continue
+		}
+		if err := prepareEntry(dir, path); err != nil {
+			return err
+		}
+		mode := zf.Mode()
+		if mode.IsDir() {
+			if err := os.MkdirAll(path, mode.Perm()|0700); err != nil {
+				return err
+			}
+			continue
+		}
+		rc, err := zf.Open()
+		if err != nil {
+			return err
+		}
+		if mode&os.ModeSymlink != 0 {
+			var target bytes.Buffer
+			if _, err = io.Copy(&target, rc); err == nil {
+				err = extractSymlink(dir, target.String(), path)
+			}
+		} else {
+			err = writeFile(path, rc, mode.Perm())
+		}
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// extractPath returns an empty path for entries that are removed by strip
+func extractPath(dir, name string, strip int) (string, error) {
+	parts := strings.Split(strings.Trim(filepath.ToSlash(name), "/"), "/")
+	if len(parts) <= strip {
+		return "", nil
+	}
+	rel := filepath.Clean(filepath.Join(parts[strip:]...))
+	if rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", xerrors.Errorf("invalid archive path '%s'", name)
+	}
+	return filepath.Join(dir, rel), nil
+}
+
+// prepareEntry removes an existing symlink at path, so that it is replaced instead of followed
+func prepareEntry(dir, path string) error {
+	if err := checkParents(dir, path); err != nil {
+		return err
+	}
+	if fi, err := os.Lstat(path); err == nil && fi.Mode()&os.ModeSymlink != 0 {
+		return os.Remove(path)
+	}
+	return nil
+}
+
+// checkParents rejects paths with a parent below dir that is a symlink
+func checkParents(dir, path string) error {
+	rel, err := filepath.Rel(dir, filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	parent := dir
+	if rel != "." {
+		for _, part := range strings.Split(rel, string(filepath.Separator)) {
+			parent = filepath.Join(parent, part)
+			fi, err := os.Lstat(parent)
+			if os.IsNotExist(err) {
+				break
+			} else if err != nil {
+				return err
+			}
+			if fi.Mode()&os.ModeSymlink != 0 {
+				return xerrors.Errorf("invalid archive path '%s': parent is a symlink", path)
+			}
+		}
+	}
+	return nil
+}
+
+// extractSymlink only creates relative symlinks that resolve inside of dir
+func extractSymlink(dir, target, path string) error {
+	if filepath.IsAbs(target) {
+		return xerrors.Errorf("invalid archive link '%s' -> '%s': absolute target", path, target)
+	}
+	rel, err := filepath.Rel(filepath.Clean(dir), filepath.Join(filepath.Dir(path), target))
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return xerrors.Errorf("invalid archive link '%s' -> '%s': target outside of directory", path, target)
+	}
+	return symlink(target, path)
+}
+
+// extractHardlink rejects links to symlinks, which would resolve relative to a different directory
+func extractHardlink(dir, target, path string) error {
+	if err := checkParents(dir, target); err != nil {
+		return err
+	}
+	fi, err := os.Lstat(target)
+	if err != nil {
+		return err
+	}
+	if !fi.Mode().IsRegular() {
+		return xerrors.Errorf("invalid archive link '%s' -> '%s': target is not a regular file", path, target)
+	}
+	return os.Link(target, path)
+}
+
+func writeFile(path string, r io.Reader, mode os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(f, r)
+	return err
+}
+
+func symlink(target, path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+		return err
+	}
+	return os.Symlink(target, path)
+}
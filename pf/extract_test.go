@@ -0,0 +1,228 @@
+package pf
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type entry struct {
+	name     string
+	typeflag byte
+	link     string
+	body     string
+}
+
+func writeTar(t *testing.T, path string, entries []entry) {
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	tw := tar.NewWriter(f)
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.name, Typeflag: e.typeflag, Linkname: e.link, Mode: 0644, Size: int64(len(e.body))}
+		if e.typeflag == tar.TypeDir {
+			hdr.Mode = 0755
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(e.body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func writeZip(t *testing.T, path string, entries []entry) {
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zw := zip.NewWriter(f)
+	for _, e := range entries {
+		hdr := &zip.FileHeader{Name: e.name}
+		body := e.body
+		switch e.typeflag {
+		case tar.TypeSymlink:
+			hdr.SetMode(os.ModeSymlink | 0777)
+			body = e.link
+		case tar.TypeDir:
+			hdr.SetMode(os.ModeDir | 0755)
+		default:
+			hdr.SetMode(0644)
+		}
+		w, err := zw.CreateHeader(hdr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestExtractOutside checks that archive entries are never written outside of the target directory
+func TestExtractOutside(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		zip     bool
+		entries []entry
+		files   map[string]string
+		fail    bool
+	}{
+		{
+			name:    "parent entry",
+			entries: []entry{{name: "../evil", typeflag: tar.TypeReg, body: "x"}},
+			fail:    true,
+		},
+		{
+			name:    "nested parent entry",
+			entries: []entry{{name: "a/../../evil", typeflag: tar.TypeReg, body: "x"}},
+			fail:    true,
+		},
+		{
+			name:    "absolute entry",
+			entries: []entry{{name: "/evil", typeflag: tar.TypeReg, body: "x"}},
+			files:   map[string]string{"evil": "x"},
+		},
+		{
+			name: "symlink then file through it",
+			entries: []entry{
+				{name: "link", typeflag: tar.TypeSymlink, link: "../outside"},
+				{name: "link/evil", typeflag: tar.TypeReg, body: "x"},
+			},
+			fail: true,
+		},
+		{
+			name: "inside symlink then file through it",
+			entries: []entry{
+				{name: "sub/", typeflag: tar.TypeDir},
+				{name: "link", typeflag: tar.TypeSymlink, link: "sub"},
+				{name: "link/evil", typeflag: tar.TypeReg, body: "x"},
+			},
+			fail: true,
+		},
+		{
+			name:    "absolute symlink",
+			entries: []entry{{name: "link", typeflag: tar.TypeSymlink, link: "/etc"}},
+			fail:    true,
+		},
+		{
+			name: "file replaces symlink",
+			entries: []entry{
+				{name: "a", typeflag: tar.TypeReg, body: "a"},
+				{name: "link", typeflag: tar.TypeSymlink, link: "a"},
+				{name: "link", typeflag: tar.TypeReg, body: "x"},
+			},
+			files: map[string]string{"a": "a", "link": "x"},
+		},
+		{
+			name: "hardlink outside",
+			entries: []entry{
+				{name: "link", typeflag: tar.TypeLink, link: "../outside/file"},
+			},
+			fail: true,
+		},
+		{
+			name: "hardlink to absolute path",
+			entries: []entry{
+				{name: "link", typeflag: tar.TypeLink, link: "/etc/passwd"},
+			},
+			fail: true,
+		},
+		{
+			name: "hardlink through symlink",
+			entries: []entry{
+				{name: "a", typeflag: tar.TypeReg, body: "a"},
+				{name: "dir", typeflag: tar.TypeSymlink, link: "."},
+				{name: "link", typeflag: tar.TypeLink, link: "dir/a"},
+			},
+			fail: true,
+		},
+		{
+			name: "hardlink inside",
+			entries: []entry{
+				{name: "a", typeflag: tar.TypeReg, body: "a"},
+				{name: "link", typeflag: tar.TypeLink, link: "a"},
+			},
+			files: map[string]string{"a": "a", "link": "a"},
+		},
+		{
+			name:    "zip slip",
+			zip:     true,
+			entries: []entry{{name: "../../evil", typeflag: tar.TypeReg, body: "x"}},
+			fail:    true,
+		},
+		{
+			name:    "zip slip with backslashes",
+			zip:     true,
+			entries: []entry{{name: `a/..\..\evil`, typeflag: tar.TypeReg, body: "x"}},
+			files:   map[string]string{`a/..\..\evil`: "x"},
+		},
+		{
+			name: "zip symlink then file through it",
+			zip:  true,
+			entries: []entry{
+				{name: "link", typeflag: tar.TypeSymlink, link: "../outside"},
+				{name: "link/evil", typeflag: tar.TypeReg, body: "x"},
+			},
+			fail: true,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			tmp, err := ioutil.TempDir("", "extract-test.")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(tmp)
+			dir := filepath.Join(tmp, "dir")
+			outside := filepath.Join(tmp, "outside")
+			for _, d := range []string{dir, outside} {
+				if err := os.Mkdir(d, 0777); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := ioutil.WriteFile(filepath.Join(outside, "file"), []byte("outside"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			archive := filepath.Join(tmp, "archive")
+			if tt.zip {
+				writeZip(t, archive, tt.entries)
+			} else {
+				writeTar(t, archive, tt.entries)
+			}
+
+			err = Extract(archive, dir, 0)
+			if tt.fail && err == nil {
+				t.Error("expected error")
+			} else if !tt.fail && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+
+			for _, path := range []string{filepath.Join(tmp, "evil"), filepath.Join(outside, "evil")} {
+				if _, err := os.Lstat(path); !os.IsNotExist(err) {
+					t.Errorf("expected no file at %s", path)
+				}
+			}
+			if out, err := ioutil.ReadFile(filepath.Join(outside, "file")); err != nil || string(out) != "outside" {
+				t.Errorf("outside file modified: %q, %v", out, err)
+			}
+			for name, body := range tt.files {
+				if out, err := ioutil.ReadFile(filepath.Join(dir, name)); err != nil || string(out) != body {
+					t.Errorf("unexpected contents for %s: %q, %v", name, out, err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,252 @@
+package pf
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/sclevine/packfile"
+	"github.com/sclevine/packfile/metadata"
+)
+
+// Runner implements packfile.RequireRunner, packfile.TestRunner, and packfile.ProvideRunner
+// using functions that receive a *Layer, which is closed when each function returns.
+type Runner struct {
+	RequireFunc func(l *Layer) error
+	TestFunc    func(l *Layer) error
+	ProvideFunc func(l *Layer) error
+	ID          string
+}
+
+func (r Runner) Require(st packfile.Streamer, env packfile.EnvMap, md packfile.Metadata) error {
+	return r.call(r.RequireFunc, &Layer{Streamer: st, Env: env, Metadata: md})
+}
+
+func (r Runner) Test(st packfile.Streamer, env packfile.EnvMap, md packfile.Metadata) error {
+	return r.call(r.TestFunc, &Layer{Streamer: st, Env: env, Metadata: md})
+}
+
+func (r Runner) Provide(st packfile.Streamer, env packfile.EnvMap, md packfile.Metadata, deps []packfile.Dep) error {
+	return r.call(r.ProvideFunc, &Layer{Streamer: st, Env: env, Metadata: md, Deps: deps})
+}
+
+func (r Runner) Version() string {
+	return r.ID
+}
+
+func (Runner) call(fn func(l *Layer) error, l *Layer) (err error) {
+	if fn == nil {
+		return nil
+	}
+	defer func() {
+		if cerr := l.Close(); err == nil {
+			err = cerr
+		}
+	}()
+	return fn(l)
+}
+
+// Layer provides helpers for Go runners. Resources created by helpers are released by Close.
+type Layer struct {
+	Streamer packfile.Streamer
+	Env      packfile.EnvMap
+	Metadata packfile.Metadata
+	Deps     []packfile.Dep
+	dl       *Downloader
+}
+
+func (l *Layer) Close() error {
+	if l.dl == nil {
+		return nil
+	}
+	return l.dl.Close()
+}
+
+func (l *Layer) AppDir() string {
+	return l.Env["APP"]
+}
+
+func (l *Layer) Dir() string {
+	return l.Env["LAYER"]
+}
+
+// Download returns the path to a dep, which is removed when the layer is closed
+func (l *Layer) Download(name, version string) (string, error) {
+	if l.dl == nil {
+		dl, err := NewDownloader(l.Metadata, l.Deps)
+		if err != nil {
+			return "", err
+		}
+		l.dl = dl
+	}
+	return l.dl.GetFile(name, version)
+}
+
+// Install downloads a dep and extracts it into the layer directory
+func (l *Layer) Install(name, version string, strip int) error {
+	path, err := l.Download(name, version)
+	if err != nil {
+		return err
+	}
+	return Extract(path, l.Dir(), strip)
+}
+
+// WriteEnv adds environment variables to the layer, like provide.env
+func (l *Layer) WriteEnv(envs packfile.Envs) error {
+	for dir, env := range map[string][]packfile.Env{
+		"env":        envs.Both,
+		"env.build":  envs.Build,
+		"env.launch": envs.Launch,
+	} {
+		for _, e := range env {
+			if e.Op == "" {
+				e.Op = "override"
+			}
+			path := filepath.Join(l.Dir(), dir, e.Name+"."+e.Op)
+			if err := writeFile(path, strings.NewReader(e.Value), 0666); err != nil {
+				return err
+			}
+			if e.Delim != "" {
+				path := filepath.Join(l.Dir(), dir, e.Name+".delim")
+				if err := writeFile(path, strings.NewReader(e.Delim), 0666); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// WriteProfile adds a script to the layer's profile.d directory, like provide.profile
+func (l *Layer) WriteProfile(name, script string) error {
+	return writeFile(filepath.Join(l.Dir(), "profile.d", name), strings.NewReader(script), 0777)
+}
+
+type LinkedLayer struct {
+	Path     string
+	Version  string
+	Metadata metadata.Metadata
+}
+
+// Linked returns information about a linked layer using the names from its link
+func (l *Layer) Linked(link packfile.Link) LinkedLayer {
+	out := LinkedLayer{
+		Path:    l.Env[link.PathEnv],
+		Version: l.Env[link.VersionEnv],
+	}
+	if link.MetadataEnv != "" {
+		out.Metadata = l.Metadata.Link(link.MetadataEnv)
+	}
+	return out
+}
+
+// Run executes a command in the app directory with the layer's environment and output streams
+func (l *Layer) Run(name string, args ...string) error {
+	cmd := l.command(name, args...)
+	cmd.Stdout = l.Streamer.Stdout()
+	return cmd.Run()
+}
+
+// Output executes a command like Run, but returns its output with surrounding whitespace removed
+func (l *Layer) Output(name string, args ...string) (string, error) {
+	cmd := l.command(name, args...)
+	out := &bytes.Buffer{}
+	cmd.Stdout = out
+	err := cmd.Run()
+	return strings.TrimSpace(out.String()), err
+}
+
+func (l *Layer) command(name string, args ...string) *exec.Cmd {
+	if path, ok := l.lookPath(name); ok {
+		name = path
+	}
+	cmd := exec.Command(name, args...)
+	cmd.Dir = l.AppDir()
+	cmd.Env = l.Env.Environ()
+	cmd.Stderr = l.Streamer.Stderr()
+	return cmd
+}
+
+// lookPath uses PATH from the layer's environment, which includes linked layers
+func (l *Layer) lookPath(name string) (string, bool) {
+	if strings.Contains(name, "/") {
+		return "", false
+	}
+	for _, dir := range filepath.SplitList(l.Env["PATH"]) {
+		path := filepath.Join(dir, name)
+		if fi, err := os.Stat(path); err == nil && !fi.IsDir() && fi.Mode()&0111 != 0 {
+			return path, true
+		}
+	}
+	return "", false
+}
+
+// Move moves src (relative to the app directory) to dst (relative to the layer directory)
+func (l *Layer) Move(src, dst string) error {
+	if !filepath.IsAbs(src) {
+		src = filepath.Join(l.AppDir(), src)
+	}
+	if !filepath.IsAbs(dst) {
+		dst = filepath.Join(l.Dir(), dst)
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0777); err != nil {
+		return err
+	}
+	if err := os.Rename(src, dst); err == nil || !isCrossDevice(err) {
+		return err
+	}
+	if err := copyTree(src, dst); err != nil {
+		return err
+	}
+	return os.RemoveAll(src)
+}
+
+func isCrossDevice(err error) bool {
+	if lerr, ok := err.(*os.LinkError); ok {
+		return lerr.Err == syscall.EXDEV
+	}
+	return false
+}
+
+func copyTree(src, dst string) error {
+	return filepath.Walk(src, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		switch {
+		case fi.IsDir():
+			return os.MkdirAll(target, fi.Mode().Perm()|0700)
+		case fi.Mode()&os.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return symlink(link, target)
+		case fi.Mode().IsRegular():
+			f, err := os.Open(path)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			return writeFile(target, f, fi.Mode().Perm())
+		}
+		return nil
+	})
+}
+
+// ReadFile reads a file relative to the app directory
+func (l *Layer) ReadFile(path string) ([]byte, error) {
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(l.AppDir(), path)
+	}
+	return ioutil.ReadFile(path)
+}
@@ -61,6 +61,12 @@ func mergePackfiles(dst *packfile.Packfile, src packfile.Packfile) {
 	if src.Config.Shell != "" {
 		dst.Config.Shell = src.Config.Shell
 	}
+	if src.Config.Verify != "" {
+		dst.Config.Verify = src.Config.Verify
+	}
+	if len(src.Config.Vars) > 0 {
+		dst.Config.Vars = append(src.Config.Vars, dst.Config.Vars...)
+	}
 	if len(src.Processes) > 0 {
 		dst.Processes = append(src.Processes, dst.Processes...)
 	}
@@ -76,6 +82,18 @@ func mergePackfiles(dst *packfile.Packfile, src packfile.Packfile) {
 	if len(src.Stacks) > 0 {
 		dst.Stacks = append(src.Stacks, dst.Stacks...)
 	}
+	if len(src.Plan.Layers) > 0 {
+		dst.Plan.Layers = append(src.Plan.Layers, dst.Plan.Layers...)
+	}
+	if len(src.Plan.Provides) > 0 {
+		dst.Plan.Provides = append(src.Plan.Provides, dst.Plan.Provides...)
+	}
+	if len(src.Plan.Requires) > 0 {
+		dst.Plan.Requires = append(src.Plan.Requires, dst.Plan.Requires...)
+	}
+	if len(src.Or) > 0 {
+		dst.Or = append(src.Or, dst.Or...)
+	}
 }
 
 func getPackfile(dir string) (pf packfile.Packfile, err error) {
@@ -4,7 +4,6 @@ import (
 	"io/ioutil"
 	"log"
 	"net/http"
-	"os/exec"
 
 	"golang.org/x/xerrors"
 
@@ -15,18 +14,39 @@ import (
 
 var BuildID string
 
+var node = pf.Runner{
+	TestFunc: func(l *pf.Layer) error {
+		version, err := l.Metadata.Read("version")
+		if xerrors.Is(err, metadata.ErrNotExist) {
+			version = "*"
+		} else if err != nil {
+			return err
+		}
+		resp, err := http.Get("https://semver.io/node/resolve/" + version)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		vbytes, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return err
+		}
+		return l.Metadata.Write("v"+string(vbytes), "version")
+	},
+	ProvideFunc: func(l *pf.Layer) error {
+		return l.Install("node", "", 1)
+	},
+	ID: BuildID,
+}
+
 var buildpack = &packfile.Packfile{
 	Layers: []packfile.Layer{
 		{
 			Name:  "nodejs",
 			Store: true,
 			Provide: &packfile.Provide{
-				Test: &packfile.Test{
-					Runner: nodeLayer{},
-				},
-				Run: &packfile.Run{
-					Runner: nodeLayer{},
-				},
+				Test: &packfile.Test{Runner: node},
+				Run:  &packfile.Run{Runner: node},
 				Deps: []packfile.Dep{
 					{
 						Name:    "node",
@@ -39,47 +59,6 @@ var buildpack = &packfile.Packfile{
 	},
 }
 
-type nodeLayer struct{}
-
-func (nodeLayer) Provide(st packfile.Streamer, env packfile.EnvMap, md packfile.Metadata, deps []packfile.Dep) error {
-	dl, err := pf.NewDownloader(md, deps)
-	if err != nil {
-		return err
-	}
-	defer dl.Close()
-	path, err := dl.GetFile("node", "")
-	if err != nil {
-		return err
-	}
-
-	cmd := exec.Command("tar", "-C", env["LAYER"], "-xJf", path, "--strip-components=1")
-	cmd.Stdout = st.Stdout()
-	cmd.Stderr = st.Stderr()
-	return cmd.Run()
-}
-
-func (nodeLayer) Test(st packfile.Streamer, env packfile.EnvMap, md packfile.Metadata) error {
-	version, err := md.Read("version")
-	if xerrors.Is(err, metadata.ErrNotExist) {
-		version = "*"
-	} else if err != nil {
-		return err
-	}
-	resp, err := http.Get("https://semver.io/node/resolve/" + version)
-	if err != nil {
-		return err
-	}
-	vbytes, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return err
-	}
-	return md.Write("v"+string(vbytes), "version")
-}
-
-func (nodeLayer) Version() string {
-	return BuildID
-}
-
 func main() {
 	if err := pf.Run(buildpack); err != nil {
 		log.Fatalf("Error: %s", err)
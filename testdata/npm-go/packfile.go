@@ -4,11 +4,7 @@ import (
 	"crypto/sha256"
 	"encoding/json"
 	"fmt"
-	"io"
 	"log"
-	"os"
-	"os/exec"
-	"path/filepath"
 
 	"github.com/sclevine/packfile"
 	"github.com/sclevine/packfile/pf"
@@ -16,97 +12,75 @@ import (
 
 var BuildID string
 
+var node = pf.Runner{
+	RequireFunc: func(l *pf.Layer) error {
+		contents, err := l.ReadFile("package.json")
+		if err != nil {
+			return err
+		}
+		var packageJSON struct {
+			Engines struct {
+				Node string
+			}
+		}
+		if err := json.Unmarshal(contents, &packageJSON); err != nil {
+			return err
+		}
+		return l.Metadata.Write(packageJSON.Engines.Node, "version")
+	},
+}
+
+var npmCache = packfile.Link{Name: "npm-cache", PathEnv: "NPM_CACHE"}
+
+var modules = pf.Runner{
+	TestFunc: func(l *pf.Layer) error {
+		lock, err := l.ReadFile("package-lock.json")
+		if err != nil {
+			return nil
+		}
+		nodeVersion, err := l.Output("node", "-v")
+		if err != nil {
+			return err
+		}
+		return l.Metadata.Write(fmt.Sprintf("%x-%s", sha256.Sum256(lock), nodeVersion), "version")
+	},
+	ProvideFunc: func(l *pf.Layer) error {
+		if err := l.Run("npm", "ci", "--unsafe-perm", "--cache", l.Linked(npmCache).Path); err != nil {
+			return err
+		}
+		return l.Move("node_modules", "node_modules")
+	},
+	ID: BuildID,
+}
+
 var buildpack = &packfile.Packfile{
 	Caches: []packfile.Cache{
 		{Name: "npm-cache"},
 	},
 	Layers: []packfile.Layer{
 		{
-			Name:   "nodejs",
-			Expose: true,
-			Export: true,
-			Require: &packfile.Require{
-				Runner: nodeLayer{},
-			},
+			Name:    "nodejs",
+			Expose:  true,
+			Export:  true,
+			Require: &packfile.Require{Runner: node},
 		},
 		{
 			Name:   "modules",
 			Expose: true,
 			Build: &packfile.Provide{
-				Run: &packfile.Run{
-					Runner: modulesLayer{},
-				},
-				Test: &packfile.Test{
-					Runner: modulesLayer{},
-				},
+				Run:  &packfile.Run{Runner: modules},
+				Test: &packfile.Test{Runner: modules},
 				Env: packfile.Envs{
 					Both: []packfile.Env{
 						{Name: "NODE_PATH", Value: "{{.Layer}}/node_modules"},
 					},
 				},
-				Links: []packfile.Link{
-					{Name: "npm-cache", PathEnv: "NPM_CACHE"},
-				},
+				Links: []packfile.Link{npmCache},
 			},
 		},
 	},
 }
 
-type nodeLayer struct{}
-
-func (nodeLayer) Require(st packfile.Streamer, env packfile.EnvMap, md packfile.Metadata) error {
-	f, err := os.Open("package.json")
-	if err != nil {
-		return err
-	}
-	defer f.Close()
-	var packageJSON struct {
-		Engines struct {
-			Node string
-		}
-	}
-	if err := json.NewDecoder(f).Decode(&packageJSON); err != nil {
-		return err
-	}
-	return md.Write(packageJSON.Engines.Node, "version")
-}
-
-type modulesLayer struct{}
-
-func (modulesLayer) Test(st packfile.Streamer, env packfile.EnvMap, md packfile.Metadata) error {
-	h := sha256.New()
-	f, err := os.Open("package-lock.json")
-	if err != nil {
-		return nil
-	}
-	defer f.Close()
-	if _, err := io.Copy(h, f); err !=  nil  {
-		return err
-	}
-	nodeVersion, err := exec.Command("node", "-v").Output()
-	if err != nil {
-		return err
-	}
-	return md.Write(fmt.Sprintf("%x-%s", h.Sum(nil), nodeVersion), "version")
-}
-
-func (modulesLayer) Provide(st packfile.Streamer, env packfile.EnvMap, md packfile.Metadata, deps []packfile.Dep) error {
-	cmd := exec.Command("npm", "ci", "--unsafe-perm", "--cache", env["NPM_CACHE"])
-	cmd.Stdout = st.Stdout()
-	cmd.Stderr = st.Stderr()
-	if err := cmd.Run(); err != nil {
-		return err
-	}
-	mv := exec.Command("mv", "node_modules", filepath.Join(env["LAYER"], "node_modules"))
-	mv.Stdout = st.Stdout()
-	mv.Stderr = st.Stderr()
-	return mv.Run()
-}
-
-func (modulesLayer) Version() string {
-	return BuildID
-}
-
 func main() {
 	if err := pf.Run(buildpack); err != nil {
 		log.Fatalf("Error: %s", err)